@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/backup"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/config"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/diff"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/srv"
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	sdkconfig "github.com/aserto-dev/idp-plugin-sdk/config"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// commands are run when the binary is started with a command name as its
+// first argument, instead of serving the plugin.
+var commands = map[string]func(args []string) error{ // nolint:gochecknoglobals // command table
+	"backup":   runBackup,
+	"restore":  runRestore,
+	"diff":     runDiff,
+	"diagnose": runDiagnose,
+}
+
+func runBackup(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	configPath := flags.String("config", "", "path to a JSON file with the plugin configuration")
+	out := flags.String("out", "", "path of the backup archive to write")
+	_ = flags.Parse(args)
+
+	if *out == "" {
+		return fmt.Errorf("no output file was provided")
+	}
+
+	cfg, err := loadConfig(*configPath, plugin.OperationTypeRead)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := operationContext(cfg)
+	defer cancel()
+
+	conn, err := cfg.Connect(ctx)
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close()
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ver, date, commit := config.GetVersion()
+	manifest, err := backup.Backup(ctx, conn.Directory, cfg.Tenant, backup.PluginVersion{Version: ver, Date: date, Commit: commit}, f)
+	if err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("backed up tenant %s to %s: %d users, %d applications, %d resources\n",
+		manifest.TenantID, *out, manifest.Counts[backup.UsersFile], manifest.Counts[backup.ApplicationsFile], manifest.Counts[backup.ResourcesFile])
+	return nil
+}
+
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	configPath := flags.String("config", "", "path to a JSON file with the plugin configuration")
+	in := flags.String("in", "", "path of the backup archive to restore")
+	force := flags.Bool("force", false, "restore into a tenant other than the one backed up")
+	_ = flags.Parse(args)
+
+	if *in == "" {
+		return fmt.Errorf("no input file was provided")
+	}
+
+	cfg, err := loadConfig(*configPath, plugin.OperationTypeWrite)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	archive, err := backup.Read(f)
+	if err != nil {
+		return err
+	}
+
+	if archive.Manifest.TenantID != cfg.Tenant && !*force {
+		return fmt.Errorf("backup is of tenant %s, not %s; use -force to restore it anyway", archive.Manifest.TenantID, cfg.Tenant)
+	}
+
+	ctx, cancel := operationContext(cfg)
+	defer cancel()
+
+	conn, err := cfg.Connect(ctx)
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close()
+
+	stats, err := backup.Restore(ctx, conn.Directory, archive)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("restored %s taken %s: %d users received (%d created, %d updated, %d errors), %d applications, %d resources\n",
+		*in, archive.Manifest.CreatedAt.Format("2006-01-02T15:04:05Z"), stats.Users.Received, stats.Users.Created,
+		stats.Users.Updated, stats.Users.Errors, stats.Applications, stats.Resources)
+	return nil
+}
+
+func runDiff(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	currentConfig := flags.String("current", "", "path to a JSON file with the plugin configuration of the current users")
+	currentFile := flags.String("current-file", "", "path to a JSON Lines snapshot of the current users")
+	incomingConfig := flags.String("incoming", "", "path to a JSON file with the plugin configuration of the incoming users")
+	incomingFile := flags.String("incoming-file", "", "path to a JSON Lines snapshot of the incoming users")
+	format := flags.String("format", "text", "report format: text or json")
+	out := flags.String("out", "", "path of the report to write, stdout if empty")
+	_ = flags.Parse(args)
+
+	current, err := readSource(*currentConfig, *currentFile)
+	if err != nil {
+		return fmt.Errorf("read current users: %w", err)
+	}
+
+	incoming, err := readSource(*incomingConfig, *incomingFile)
+	if err != nil {
+		return fmt.Errorf("read incoming users: %w", err)
+	}
+
+	report := diff.Compare(current, incoming)
+
+	if *out == "" {
+		return writeReport(report, *format, os.Stdout)
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := writeReport(report, *format, f); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+func writeReport(report *diff.Report, format string, w io.Writer) error {
+	switch format {
+	case "text":
+		return report.WriteText(w)
+	case "json":
+		return report.WriteJSON(w)
+	default:
+		return fmt.Errorf("invalid format %q", format)
+	}
+}
+
+// readSource reads all users through the plugin, either from the directory
+// described by a config file or from a snapshot file.
+func readSource(configPath, file string) ([]*api.User, error) {
+	var cfg *config.AsertoConfig
+	switch {
+	case configPath != "" && file != "":
+		return nil, fmt.Errorf("a config and a snapshot file are mutually exclusive")
+	case file != "":
+		cfg = &config.AsertoConfig{File: file}
+	default:
+		var err error
+		cfg, err = loadConfig(configPath, plugin.OperationTypeRead)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p := srv.NewAsertoPlugin()
+	defer p.Close()
+	if err := p.Open(cfg, plugin.OperationTypeRead); err != nil {
+		return nil, err
+	}
+
+	var users []*api.User
+	for {
+		page, err := p.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, page...)
+	}
+
+	if _, err := p.Close(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// operationContext bounds a command by the operation timeout of cfg.
+func operationContext(cfg *config.AsertoConfig) (context.Context, context.CancelFunc) {
+	if deadline := cfg.OperationDeadline(); deadline > 0 {
+		return context.WithTimeout(context.Background(), deadline)
+	}
+
+	return context.WithCancel(context.Background())
+}
+
+func runDiagnose(args []string) error {
+	flags := flag.NewFlagSet("diagnose", flag.ExitOnError)
+	configPath := flags.String("config", "", "path to a JSON file with the plugin configuration")
+	readOnly := flags.Bool("read-only", false, "only check what exporting users needs")
+	_ = flags.Parse(args)
+
+	cfg, err := parseConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	operation := plugin.OperationTypeWrite
+	if *readOnly {
+		operation = plugin.OperationTypeRead
+	}
+
+	d := cfg.Diagnose(context.Background(), operation)
+	if err := d.WriteText(os.Stdout); err != nil {
+		return err
+	}
+
+	return d.Err()
+}
+
+// loadConfig reads a JSON object using the plugin attribute names, such as
+// {"authorizer": "...", "tenant": "...", "api-key": "..."}, and validates it.
+func loadConfig(path string, operation plugin.OperationType) (*config.AsertoConfig, error) {
+	cfg, err := parseConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(operation); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func parseConfig(path string) (*config.AsertoConfig, error) {
+	if path == "" {
+		return nil, fmt.Errorf("no config file was provided")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := &structpb.Struct{}
+	if err := protojson.Unmarshal(data, values); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+
+	cfg := &config.AsertoConfig{}
+	if err := sdkconfig.NewConfig(values, cfg); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+
+	return cfg, nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/srv"
 	"github.com/aserto-dev/idp-plugin-sdk/plugin"
@@ -9,6 +10,15 @@ import (
 
 func main() {
 
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatal(err.Error())
+			}
+			return
+		}
+	}
+
 	options := &plugin.Options{
 		Handler: srv.NewAsertoPlugin(),
 	}
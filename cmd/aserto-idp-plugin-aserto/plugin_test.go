@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/fakedir"
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	proto "github.com/aserto-dev/go-grpc/aserto/idpplugin/v1"
+	"github.com/aserto-dev/idp-plugin-sdk/grpcplugin"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	goplugin "github.com/hashicorp/go-plugin"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const testTenant = "3dbaa470-9c4f-11ec-9a7e-0a4d3d4d7a9c"
+
+// pluginBinary is built once by TestMain and started by every test.
+var pluginBinary string // nolint:gochecknoglobals // shared by tests
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "aserto-idp-plugin")
+	if err != nil {
+		panic(err)
+	}
+
+	pluginBinary = filepath.Join(dir, "aserto-idp-plugin-aserto")
+	if runtime.GOOS == "windows" {
+		pluginBinary += ".exe"
+	}
+
+	build := exec.Command("go", "build", "-o", pluginBinary, ".")
+	build.Stdout = os.Stdout
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// startPlugin runs the plugin binary the way idp-cli does and returns its client.
+func startPlugin(t *testing.T) grpcplugin.PluginClient {
+	t.Helper()
+
+	client := goplugin.NewClient(&goplugin.ClientConfig{
+		HandshakeConfig:  plugin.Handshake,
+		Plugins:          plugin.PluginMap,
+		Cmd:              exec.Command(pluginBinary), // nolint:gosec // built by TestMain
+		AllowedProtocols: []goplugin.Protocol{goplugin.ProtocolGRPC},
+		SyncStdout:       io.Discard,
+		SyncStderr:       io.Discard,
+	})
+	t.Cleanup(client.Kill)
+
+	rpcClient, err := client.Client()
+	require.NoError(t, err)
+
+	raw, err := rpcClient.Dispense("idp-plugin")
+	require.NoError(t, err)
+
+	return raw.(grpcplugin.PluginClient)
+}
+
+// startDirectory serves a fake directory on a local TCP port and returns the
+// plugin configuration pointing at it.
+func startDirectory(t *testing.T, users ...*api.User) (*fakedir.Server, *structpb.Struct) {
+	t.Helper()
+
+	server, err := fakedir.New(users...)
+	require.NoError(t, err)
+	server.TenantID = testTenant
+
+	addr, err := server.StartTCP()
+	require.NoError(t, err)
+	t.Cleanup(server.Stop)
+
+	cfg, err := structpb.NewStruct(map[string]interface{}{
+		"authorizer": addr,
+		"tenant":     testTenant,
+		"insecure":   true,
+	})
+	require.NoError(t, err)
+
+	return server, cfg
+}
+
+func testUser(id, name, email string) *api.User {
+	return &api.User{
+		Id:          id,
+		DisplayName: name,
+		Email:       email,
+		Identities: map[string]*api.IdentitySource{
+			"local|" + id: {Kind: api.IdentityKind_IDENTITY_KIND_PID, Provider: "local", Verified: true},
+			email:         {Kind: api.IdentityKind_IDENTITY_KIND_EMAIL, Provider: "local", Verified: true},
+		},
+	}
+}
+
+func TestPluginInfo(t *testing.T) {
+	assert := require.New(t)
+	client := startPlugin(t)
+
+	resp, err := client.Info(context.Background(), &proto.InfoRequest{})
+	assert.NoError(err)
+	assert.Equal("Aserto plugin", resp.Description)
+
+	names := map[string]bool{}
+	for _, c := range resp.Configs {
+		names[c.Name] = true
+	}
+	assert.True(names["authorizer"])
+	assert.True(names["tenant"])
+	assert.True(names["api-key"])
+}
+
+func TestPluginValidate(t *testing.T) {
+	assert := require.New(t)
+	client := startPlugin(t)
+	_, cfg := startDirectory(t)
+
+	_, err := client.Validate(context.Background(), &proto.ValidateRequest{
+		Config: cfg,
+		OpType: proto.OperationType_OPERATION_TYPE_EXPORT,
+	})
+	assert.NoError(err)
+
+	cfg.Fields["tenant"] = structpb.NewStringValue("")
+	_, err = client.Validate(context.Background(), &proto.ValidateRequest{
+		Config: cfg,
+		OpType: proto.OperationType_OPERATION_TYPE_EXPORT,
+	})
+	assert.Error(err)
+	assert.Contains(err.Error(), "no tenant was provided")
+}
+
+func TestPluginExport(t *testing.T) {
+	assert := require.New(t)
+	client := startPlugin(t)
+	_, cfg := startDirectory(t,
+		testUser("1", "First Last", "first@unit.com"),
+		testUser("2", "Second Last", "second@unit.com"),
+	)
+
+	stream, err := client.Export(context.Background(), &proto.ExportRequest{Config: cfg})
+	assert.NoError(err)
+
+	var users []*api.User
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(err)
+		assert.Nil(resp.GetError())
+		users = append(users, resp.GetUser())
+	}
+
+	assert.Len(users, 2)
+	assert.Equal("First Last", users[0].DisplayName)
+}
+
+func TestPluginImport(t *testing.T) {
+	assert := require.New(t)
+	client := startPlugin(t)
+	server, cfg := startDirectory(t, testUser("1", "First Last", "first@unit.com"))
+
+	stream, err := client.Import(context.Background())
+	assert.NoError(err)
+	assert.NoError(stream.Send(&proto.ImportRequest{Data: &proto.ImportRequest_Config{Config: cfg}}))
+	assert.NoError(stream.Send(&proto.ImportRequest{Data: &proto.ImportRequest_User{User: testUser("1", "First Renamed", "first@unit.com")}}))
+	assert.NoError(stream.Send(&proto.ImportRequest{Data: &proto.ImportRequest_User{User: testUser("2", "Second Last", "second@unit.com")}}))
+	assert.NoError(stream.CloseSend())
+
+	var stats *api.UserProcessStats
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(err)
+		assert.Nil(resp.GetError())
+		if resp.GetStats() != nil {
+			stats = resp.GetStats()
+		}
+	}
+
+	assert.NotNil(stats)
+	assert.Equal(int32(2), stats.Received)
+	assert.Equal(int32(1), stats.Created)
+	assert.Equal(int32(1), stats.Updated)
+
+	user, ok := server.Store.Get("1")
+	assert.True(ok)
+	assert.Equal("First Renamed", user.DisplayName)
+}
+
+func TestPluginDelete(t *testing.T) {
+	assert := require.New(t)
+	client := startPlugin(t)
+	id := "bd397e35-6333-11ec-b5cf-02a489f227f9"
+	server, cfg := startDirectory(t,
+		testUser(id, "First Last", "first@unit.com"),
+		testUser("2", "Second Last", "second@unit.com"),
+	)
+
+	stream, err := client.Delete(context.Background())
+	assert.NoError(err)
+	assert.NoError(stream.Send(&proto.DeleteRequest{Data: &proto.DeleteRequest_Config{Config: cfg}}))
+	assert.NoError(stream.Send(&proto.DeleteRequest{Data: &proto.DeleteRequest_UserId{UserId: id}}))
+	assert.NoError(stream.Send(&proto.DeleteRequest{Data: &proto.DeleteRequest_UserId{UserId: "bd397e35-6333-11ec-b5cf-000000000000"}}))
+	assert.NoError(stream.CloseSend())
+
+	var errs []string
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(err)
+		if resp.GetError() != nil {
+			errs = append(errs, resp.GetError().Message)
+		}
+	}
+
+	assert.Len(errs, 1)
+	assert.Contains(errs[0], "not found")
+
+	_, ok := server.Store.Get(id)
+	assert.False(ok)
+	assert.Len(server.Store.Users(), 1)
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func tokenServer(t *testing.T, expiresIn int) (*httptest.Server, *int32) {
+	t.Helper()
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "client" || secret != "s3cret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+
+		if r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "read write" {
+			http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+			return
+		}
+
+		n := atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":%d}`, n, expiresIn)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &calls
+}
+
+func TestClientCredentialsCachesToken(t *testing.T) {
+	assert := require.New(t)
+	server, calls := tokenServer(t, 3600)
+	creds := &ClientCredentials{TokenURL: server.URL, ClientID: "client", ClientSecret: "s3cret", Scopes: []string{"read", "write"}}
+
+	md, err := creds.GetRequestMetadata(context.Background())
+	assert.NoError(err)
+	assert.Equal("bearer token-1", md["authorization"])
+
+	md, err = creds.GetRequestMetadata(context.Background())
+	assert.NoError(err)
+	assert.Equal("bearer token-1", md["authorization"])
+	assert.Equal(int32(1), atomic.LoadInt32(calls))
+}
+
+func TestClientCredentialsRefreshesExpiringToken(t *testing.T) {
+	assert := require.New(t)
+	server, calls := tokenServer(t, 10)
+	creds := &ClientCredentials{TokenURL: server.URL, ClientID: "client", ClientSecret: "s3cret", Scopes: []string{"read", "write"}}
+
+	token, err := creds.Token(context.Background())
+	assert.NoError(err)
+	assert.Equal("token-1", token)
+
+	token, err = creds.Token(context.Background())
+	assert.NoError(err)
+	assert.Equal("token-2", token)
+	assert.Equal(int32(2), atomic.LoadInt32(calls))
+}
+
+func TestClientCredentialsInvalidClient(t *testing.T) {
+	assert := require.New(t)
+	server, _ := tokenServer(t, 3600)
+	creds := &ClientCredentials{TokenURL: server.URL, ClientID: "client", ClientSecret: "wrong"}
+
+	_, err := creds.Token(context.Background())
+	assert.Error(err)
+	assert.Contains(err.Error(), "401 Unauthorized")
+	assert.NotContains(err.Error(), "wrong")
+}
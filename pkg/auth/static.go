@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"context"
+	"strings"
+)
+
+// Static attaches the same authorization header to every call.
+type Static struct {
+	Authorization string
+}
+
+// APIKey authenticates calls with an Aserto API key.
+func APIKey(key string) *Static {
+	return &Static{Authorization: "basic " + key}
+}
+
+// Token authenticates calls with a bearer token. A token that already names
+// its scheme, such as "bearer <token>", is sent as is.
+func Token(token string) *Static {
+	if strings.Contains(token, " ") {
+		return &Static{Authorization: token}
+	}
+
+	return &Static{Authorization: "bearer " + token}
+}
+
+func (s *Static) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": s.Authorization}, nil
+}
+
+func (s *Static) RequireTransportSecurity() bool {
+	return true
+}
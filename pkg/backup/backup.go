@@ -0,0 +1,267 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/filedir"
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// FormatVersion is the archive layout version written to the manifest.
+const FormatVersion = 1
+
+// Names of the files in a backup archive, also used as keys of Manifest.Counts.
+const (
+	UsersFile        = "users.jsonl"
+	ApplicationsFile = "applications.jsonl"
+	ResourcesFile    = "resources.jsonl"
+
+	manifestFile = "manifest.json"
+	pageSize     = int32(100)
+)
+
+// dataFiles are the files every archive holds, each with a checksum in the
+// manifest.
+var dataFiles = []string{UsersFile, ApplicationsFile, ResourcesFile}
+
+// Manifest describes the content of a backup archive.
+type Manifest struct {
+	Version   int               `json:"version"`
+	TenantID  string            `json:"tenant_id"`
+	CreatedAt time.Time         `json:"created_at"`
+	Plugin    PluginVersion     `json:"plugin"`
+	Counts    map[string]int    `json:"counts"`
+	Checksums map[string]string `json:"checksums"`
+}
+
+type PluginVersion struct {
+	Version string `json:"version"`
+	Date    string `json:"date"`
+	Commit  string `json:"commit"`
+}
+
+// Application holds the attributes of one application of a user.
+type Application struct {
+	UserID     string          `json:"user_id"`
+	Name       string          `json:"name"`
+	Attributes json.RawMessage `json:"attributes"`
+}
+
+// Resource holds the value of a directory resource.
+type Resource struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Backup captures the users, user applications and resources of the tenant
+// behind client and writes them to w as a gzipped tar archive.
+func Backup(ctx context.Context, client dir.DirectoryClient, tenantID string, version PluginVersion, w io.Writer) (*Manifest, error) {
+	users, err := listUsers(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	var usersBuf bytes.Buffer
+	if err := filedir.Encode(&usersBuf, users); err != nil {
+		return nil, fmt.Errorf("encode users: %w", err)
+	}
+
+	var appsBuf bytes.Buffer
+	apps := 0
+	for _, user := range users {
+		n, err := writeApplications(ctx, client, user.Id, &appsBuf)
+		if err != nil {
+			return nil, err
+		}
+		apps += n
+	}
+
+	var resourcesBuf bytes.Buffer
+	resources, err := writeResources(ctx, client, &resourcesBuf)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{
+		Version:   FormatVersion,
+		TenantID:  tenantID,
+		CreatedAt: time.Now().UTC(),
+		Plugin:    version,
+		Counts: map[string]int{
+			UsersFile:        len(users),
+			ApplicationsFile: apps,
+			ResourcesFile:    resources,
+		},
+		Checksums: map[string]string{},
+	}
+
+	files := map[string][]byte{
+		UsersFile:        usersBuf.Bytes(),
+		ApplicationsFile: appsBuf.Bytes(),
+		ResourcesFile:    resourcesBuf.Bytes(),
+	}
+	for name, data := range files {
+		manifest.Checksums[name] = checksum(data)
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, name := range []string{manifestFile, UsersFile, ApplicationsFile, ResourcesFile} {
+		data := manifestData
+		if name != manifestFile {
+			data = files[name]
+		}
+		if err := writeTarFile(tw, name, data, manifest.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+func listUsers(ctx context.Context, client dir.DirectoryClient) ([]*api.User, error) {
+	var users []*api.User
+	token := ""
+	for {
+		resp, err := client.ListUsers(ctx, &dir.ListUsersRequest{
+			Page: &api.PaginationRequest{
+				Size:  pageSize,
+				Token: token,
+			},
+			Base: false,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list users: %w", err)
+		}
+
+		users = append(users, resp.Results...)
+
+		token = resp.GetPage().GetNextToken()
+		if token == "" {
+			return users, nil
+		}
+	}
+}
+
+func writeApplications(ctx context.Context, client dir.DirectoryClient, userID string, w io.Writer) (int, error) {
+	resp, err := client.ListUserApplications(ctx, &dir.ListUserApplicationsRequest{Id: userID})
+	if err != nil {
+		return 0, fmt.Errorf("list applications of user %s: %w", userID, err)
+	}
+
+	enc := json.NewEncoder(w)
+	for _, name := range resp.Results {
+		attrs, err := getApplication(ctx, client, userID, name)
+		if err != nil {
+			return 0, err
+		}
+
+		data, err := protojson.Marshal(attrs)
+		if err != nil {
+			return 0, err
+		}
+
+		if err := enc.Encode(&Application{UserID: userID, Name: name, Attributes: data}); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(resp.Results), nil
+}
+
+func getApplication(ctx context.Context, client dir.DirectoryClient, userID, name string) (*api.AttrSet, error) {
+	props, err := client.GetApplProperties(ctx, &dir.GetApplPropertiesRequest{Id: userID, Name: name})
+	if err != nil {
+		return nil, fmt.Errorf("get properties of application %s of user %s: %w", name, userID, err)
+	}
+
+	roles, err := client.GetApplRoles(ctx, &dir.GetApplRolesRequest{Id: userID, Name: name})
+	if err != nil {
+		return nil, fmt.Errorf("get roles of application %s of user %s: %w", name, userID, err)
+	}
+
+	perms, err := client.GetApplPermissions(ctx, &dir.GetApplPermissionsRequest{Id: userID, Name: name})
+	if err != nil {
+		return nil, fmt.Errorf("get permissions of application %s of user %s: %w", name, userID, err)
+	}
+
+	return &api.AttrSet{
+		Properties:  props.Results,
+		Roles:       roles.Results,
+		Permissions: perms.Results,
+	}, nil
+}
+
+func writeResources(ctx context.Context, client dir.DirectoryClient, w io.Writer) (int, error) {
+	resp, err := client.ListResources(ctx, &dir.ListResourcesRequest{})
+	if err != nil {
+		return 0, fmt.Errorf("list resources: %w", err)
+	}
+
+	enc := json.NewEncoder(w)
+	for _, key := range resp.Results {
+		res, err := client.GetResource(ctx, &dir.GetResourceRequest{Key: key})
+		if err != nil {
+			return 0, fmt.Errorf("get resource %s: %w", key, err)
+		}
+
+		value := res.Value
+		if value == nil {
+			value = &structpb.Struct{}
+		}
+
+		data, err := protojson.Marshal(value)
+		if err != nil {
+			return 0, err
+		}
+
+		if err := enc.Encode(&Resource{Key: key, Value: data}); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(resp.Results), nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	_, err := tw.Write(data)
+	return err
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
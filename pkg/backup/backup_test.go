@@ -0,0 +1,141 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/mocks"
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	gomock "github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func expectTenant(client *mocks.MockDirectoryClient) {
+	client.EXPECT().ListUsers(gomock.Any(), gomock.Any()).Return(&dir.ListUsersResponse{
+		Results: []*api.User{{Id: "1", DisplayName: "First Last"}},
+		Page:    &api.PaginationResponse{NextToken: "next"},
+	}, nil)
+	client.EXPECT().ListUsers(gomock.Any(), gomock.Any()).Return(&dir.ListUsersResponse{
+		Results: []*api.User{{Id: "2", DisplayName: "First2 Last2"}},
+		Page:    &api.PaginationResponse{},
+	}, nil)
+	client.EXPECT().ListUserApplications(gomock.Any(), &dir.ListUserApplicationsRequest{Id: "1"}).Return(
+		&dir.ListUserApplicationsResponse{Results: []string{"app"}}, nil)
+	client.EXPECT().ListUserApplications(gomock.Any(), &dir.ListUserApplicationsRequest{Id: "2"}).Return(
+		&dir.ListUserApplicationsResponse{}, nil)
+	client.EXPECT().GetApplProperties(gomock.Any(), gomock.Any()).Return(
+		&dir.GetApplPropertiesResponse{Results: &structpb.Struct{Fields: map[string]*structpb.Value{"tier": structpb.NewStringValue("gold")}}}, nil)
+	client.EXPECT().GetApplRoles(gomock.Any(), gomock.Any()).Return(&dir.GetApplRolesResponse{Results: []string{"viewer"}}, nil)
+	client.EXPECT().GetApplPermissions(gomock.Any(), gomock.Any()).Return(&dir.GetApplPermissionsResponse{Results: []string{"read"}}, nil)
+	client.EXPECT().ListResources(gomock.Any(), gomock.Any()).Return(&dir.ListResourcesResponse{Results: []string{"res"}}, nil)
+	client.EXPECT().GetResource(gomock.Any(), &dir.GetResourceRequest{Key: "res"}).Return(
+		&dir.GetResourceResponse{Value: &structpb.Struct{Fields: map[string]*structpb.Value{"a": structpb.NewNumberValue(1)}}}, nil)
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	assert := require.New(t)
+	ctrl := gomock.NewController(t)
+	ctx := context.Background()
+	client := mocks.NewMockDirectoryClient(ctrl)
+	expectTenant(client)
+
+	var buf bytes.Buffer
+	manifest, err := Backup(ctx, client, "tenantID", PluginVersion{Version: "1.0.0"}, &buf)
+	assert.Nil(err)
+	assert.Equal(2, manifest.Counts[UsersFile])
+	assert.Equal(1, manifest.Counts[ApplicationsFile])
+	assert.Equal(1, manifest.Counts[ResourcesFile])
+
+	archive, err := Read(bytes.NewReader(buf.Bytes()))
+	assert.Nil(err)
+	assert.Equal("tenantID", archive.Manifest.TenantID)
+	assert.Equal("1.0.0", archive.Manifest.Plugin.Version)
+	assert.Len(archive.Users, 2)
+	assert.Len(archive.Applications, 1)
+	assert.Len(archive.Resources, 1)
+
+	stream := mocks.NewMockDirectory_LoadUsersClient(ctrl)
+	client.EXPECT().LoadUsers(gomock.Any()).Return(stream, nil)
+	stream.EXPECT().Send(gomock.Any()).Times(2).Return(nil)
+	stream.EXPECT().CloseAndRecv().Return(&dir.LoadUsersResponse{Received: 2, Created: 2}, nil)
+	client.EXPECT().SetApplProperties(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, req *dir.SetApplPropertiesRequest, _ ...interface{}) (*dir.SetApplPropertiesResponse, error) {
+			assert.Equal("1", req.Id)
+			assert.Equal("app", req.Name)
+			assert.Equal("gold", req.Properties.Fields["tier"].GetStringValue())
+			return &dir.SetApplPropertiesResponse{}, nil
+		})
+	client.EXPECT().SetApplRoles(gomock.Any(), &dir.SetApplRolesRequest{Id: "1", Name: "app", Roles: []string{"viewer"}}).Return(&dir.SetApplRolesResponse{}, nil)
+	client.EXPECT().SetApplPermissions(gomock.Any(), &dir.SetApplPermissionsRequest{Id: "1", Name: "app", Permissions: []string{"read"}}).Return(&dir.SetApplPermissionsResponse{}, nil)
+	client.EXPECT().SetResource(gomock.Any(), gomock.Any()).Return(&dir.SetResourceResponse{}, nil)
+
+	stats, err := Restore(ctx, client, archive)
+	assert.Nil(err)
+	assert.Equal(int32(2), stats.Users.Created)
+	assert.Equal(1, stats.Applications)
+	assert.Equal(1, stats.Resources)
+}
+
+// writeTestArchive returns an archive of manifest and files.
+func writeTestArchive(assert *require.Assertions, manifest *Manifest, files map[string][]byte) *bytes.Buffer {
+	data, err := json.Marshal(manifest)
+	assert.Nil(err)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	assert.Nil(writeTarFile(tw, manifestFile, data, time.Now()))
+	for name, data := range files {
+		assert.Nil(writeTarFile(tw, name, data, time.Now()))
+	}
+	assert.Nil(tw.Close())
+	assert.Nil(gz.Close())
+
+	return &buf
+}
+
+func TestReadChecksumMismatch(t *testing.T) {
+	assert := require.New(t)
+	archive := writeTestArchive(assert, &Manifest{
+		Version:   FormatVersion,
+		Checksums: map[string]string{UsersFile: checksum([]byte("original"))},
+	}, map[string][]byte{UsersFile: []byte("tampered")})
+
+	_, err := Read(archive)
+	assert.EqualError(err, "checksum mismatch for users.jsonl")
+}
+
+func TestReadMissingChecksum(t *testing.T) {
+	assert := require.New(t)
+	files := map[string][]byte{UsersFile: nil, ApplicationsFile: nil, ResourcesFile: []byte("{}\n")}
+	archive := writeTestArchive(assert, &Manifest{
+		Version: FormatVersion,
+		Checksums: map[string]string{
+			UsersFile:        checksum(nil),
+			ApplicationsFile: checksum(nil),
+		},
+	}, files)
+
+	_, err := Read(archive)
+	assert.EqualError(err, "manifest.json has no checksum for resources.jsonl")
+}
+
+func TestReadUnknownManifestEntry(t *testing.T) {
+	assert := require.New(t)
+	files := map[string][]byte{UsersFile: nil, ApplicationsFile: nil, ResourcesFile: nil, "extra.jsonl": nil}
+	checksums := map[string]string{}
+	for name, data := range files {
+		checksums[name] = checksum(data)
+	}
+	archive := writeTestArchive(assert, &Manifest{Version: FormatVersion, Checksums: checksums}, files)
+
+	_, err := Read(archive)
+	assert.EqualError(err, "manifest.json lists unknown file extra.jsonl")
+}
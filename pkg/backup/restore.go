@@ -0,0 +1,227 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/filedir"
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Archive is the verified content of a backup archive.
+type Archive struct {
+	Manifest     *Manifest
+	Users        []*api.User
+	Applications []*Application
+	Resources    []*Resource
+}
+
+// RestoreStats counts what was replayed by Restore.
+type RestoreStats struct {
+	Users        *dir.LoadUsersResponse
+	Applications int
+	Resources    int
+}
+
+// Read reads a backup archive and verifies its format version and checksums.
+func Read(r io.Reader) (*Archive, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("open archive: %w", err)
+	}
+	defer gz.Close()
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read archive: %w", err)
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", hdr.Name, err)
+		}
+		files[hdr.Name] = data
+	}
+
+	manifestData, ok := files[manifestFile]
+	if !ok {
+		return nil, fmt.Errorf("archive has no %s", manifestFile)
+	}
+
+	manifest := &Manifest{}
+	if err := json.Unmarshal(manifestData, manifest); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", manifestFile, err)
+	}
+	if manifest.Version != FormatVersion {
+		return nil, fmt.Errorf("unsupported archive version %d", manifest.Version)
+	}
+
+	for _, name := range dataFiles {
+		sum, ok := manifest.Checksums[name]
+		if !ok {
+			return nil, fmt.Errorf("%s has no checksum for %s", manifestFile, name)
+		}
+		data, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("archive has no %s", name)
+		}
+		if checksum(data) != sum {
+			return nil, fmt.Errorf("checksum mismatch for %s", name)
+		}
+	}
+	for name := range manifest.Checksums {
+		if !isDataFile(name) {
+			return nil, fmt.Errorf("%s lists unknown file %s", manifestFile, name)
+		}
+	}
+
+	archive := &Archive{Manifest: manifest}
+
+	archive.Users, err = filedir.Decode(bytes.NewReader(files[UsersFile]))
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", UsersFile, err)
+	}
+
+	if err := decodeLines(files[ApplicationsFile], func(data []byte) error {
+		app := &Application{}
+		archive.Applications = append(archive.Applications, app)
+		return json.Unmarshal(data, app)
+	}); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", ApplicationsFile, err)
+	}
+
+	if err := decodeLines(files[ResourcesFile], func(data []byte) error {
+		res := &Resource{}
+		archive.Resources = append(archive.Resources, res)
+		return json.Unmarshal(data, res)
+	}); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", ResourcesFile, err)
+	}
+
+	return archive, nil
+}
+
+// Restore replays the archive into the tenant behind client. Users are loaded
+// through LoadUsers, applications and resources through the Set RPCs.
+func Restore(ctx context.Context, client dir.DirectoryClient, archive *Archive) (*RestoreStats, error) {
+	stats := &RestoreStats{}
+
+	stream, err := client.LoadUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("open load users stream: %w", err)
+	}
+
+	for _, user := range archive.Users {
+		req := &dir.LoadUsersRequest{
+			Data: &dir.LoadUsersRequest_User{
+				User: user,
+			},
+		}
+		if err := stream.Send(req); err != nil {
+			return nil, fmt.Errorf("send user %s: %w", user.Id, err)
+		}
+	}
+
+	stats.Users, err = stream.CloseAndRecv()
+	if err != nil {
+		return nil, fmt.Errorf("close load users stream: %w", err)
+	}
+
+	for _, app := range archive.Applications {
+		if err := restoreApplication(ctx, client, app); err != nil {
+			return stats, err
+		}
+		stats.Applications++
+	}
+
+	for _, res := range archive.Resources {
+		value := &structpb.Struct{}
+		if err := protojson.Unmarshal(res.Value, value); err != nil {
+			return stats, fmt.Errorf("parse resource %s: %w", res.Key, err)
+		}
+
+		if _, err := client.SetResource(ctx, &dir.SetResourceRequest{Key: res.Key, Value: value}); err != nil {
+			return stats, fmt.Errorf("set resource %s: %w", res.Key, err)
+		}
+		stats.Resources++
+	}
+
+	return stats, nil
+}
+
+func restoreApplication(ctx context.Context, client dir.DirectoryClient, app *Application) error {
+	attrs := &api.AttrSet{}
+	if err := protojson.Unmarshal(app.Attributes, attrs); err != nil {
+		return fmt.Errorf("parse application %s of user %s: %w", app.Name, app.UserID, err)
+	}
+
+	if attrs.Properties != nil {
+		if _, err := client.SetApplProperties(ctx, &dir.SetApplPropertiesRequest{
+			Id:         app.UserID,
+			Name:       app.Name,
+			Properties: attrs.Properties,
+		}); err != nil {
+			return fmt.Errorf("set properties of application %s of user %s: %w", app.Name, app.UserID, err)
+		}
+	}
+
+	if _, err := client.SetApplRoles(ctx, &dir.SetApplRolesRequest{
+		Id:    app.UserID,
+		Name:  app.Name,
+		Roles: attrs.Roles,
+	}); err != nil {
+		return fmt.Errorf("set roles of application %s of user %s: %w", app.Name, app.UserID, err)
+	}
+
+	if _, err := client.SetApplPermissions(ctx, &dir.SetApplPermissionsRequest{
+		Id:          app.UserID,
+		Name:        app.Name,
+		Permissions: attrs.Permissions,
+	}); err != nil {
+		return fmt.Errorf("set permissions of application %s of user %s: %w", app.Name, app.UserID, err)
+	}
+
+	return nil
+}
+
+// isDataFile reports whether name is one of the data files of an archive.
+func isDataFile(name string) bool {
+	for _, file := range dataFiles {
+		if file == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func decodeLines(data []byte, decode func([]byte) error) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		if err := decode(text); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	aserto "github.com/aserto-dev/aserto-go/client"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/auth"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/proxy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+func (c *AsertoConfig) validateAuth() error {
+	switch c.AuthMethod {
+	case "", AuthMethodAPIKey:
+		if c.APIKey == "" && !c.Insecure {
+			return status.Error(codes.InvalidArgument, "no api key was provided")
+		}
+	case AuthMethodToken:
+		if (c.TokenEnv == "") == (c.TokenFile == "") {
+			return status.Error(codes.InvalidArgument, "exactly one of token-env and token-file must be provided")
+		}
+	case AuthMethodOAuth2:
+		if c.OAuth2TokenURL == "" {
+			return status.Error(codes.InvalidArgument, "no oauth2 token url was provided")
+		}
+		if c.OAuth2ClientID == "" {
+			return status.Error(codes.InvalidArgument, "no oauth2 client id was provided")
+		}
+		if (c.OAuth2ClientSecretEnv == "") == (c.OAuth2ClientSecretFile == "") {
+			return status.Error(codes.InvalidArgument, "exactly one of oauth2-client-secret-env and oauth2-client-secret-file must be provided")
+		}
+	default:
+		return status.Errorf(codes.InvalidArgument, "invalid auth method %q", c.AuthMethod)
+	}
+
+	return nil
+}
+
+// authOption returns the option authenticating calls to the authorizer, or
+// nil when calls are not authenticated.
+func (c *AsertoConfig) authOption(dialer *proxy.Dialer, tlsConf *tls.Config) (aserto.ConnectionOption, error) {
+	creds, err := c.credentials(dialer, tlsConf)
+	if err != nil || creds == nil {
+		return nil, err
+	}
+
+	return aserto.WithDialOptions(grpc.WithPerRPCCredentials(creds)), nil
+}
+
+// credentials returns the credentials of the configured auth method, or nil
+// when calls are not authenticated. OAuth2 tokens are requested through
+// dialer with the TLS settings of tlsConf, like calls to the authorizer.
+func (c *AsertoConfig) credentials(dialer *proxy.Dialer, tlsConf *tls.Config) (credentials.PerRPCCredentials, error) {
+	switch c.AuthMethod {
+	case AuthMethodToken:
+		token, err := readSecret(c.TokenEnv, c.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("read token: %w", err)
+		}
+		return auth.Token(token), nil
+
+	case AuthMethodOAuth2:
+		secret, err := readSecret(c.OAuth2ClientSecretEnv, c.OAuth2ClientSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("read oauth2 client secret: %w", err)
+		}
+		return &auth.ClientCredentials{
+			TokenURL:     c.OAuth2TokenURL,
+			ClientID:     c.OAuth2ClientID,
+			ClientSecret: secret,
+			Scopes:       splitList(c.OAuth2Scopes),
+			Audience:     c.OAuth2Audience,
+			HTTPClient:   tokenClient(dialer, tlsConf),
+		}, nil
+
+	case "":
+		// Without an explicit method, insecure connections are not authenticated.
+		if c.Insecure {
+			return nil, nil
+		}
+	}
+
+	apiKey, err := resolveSecret(c.APIKey)
+	if err != nil {
+		return nil, fmt.Errorf("resolve api key: %w", err)
+	}
+	// Validation only lets an insecure connection through without a key.
+	if apiKey == "" {
+		return nil, nil
+	}
+
+	return auth.APIKey(apiKey), nil
+}
+
+// tokenClient returns the client calling the OAuth2 token endpoint. The
+// server name override only applies to the authorizer.
+func tokenClient(dialer *proxy.Dialer, tlsConf *tls.Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = dialer.Proxy
+	if tlsConf != nil {
+		transport.TLSClientConfig = tlsConf.Clone()
+		transport.TLSClientConfig.ServerName = ""
+	}
+
+	return &http.Client{Transport: transport}
+}
+
+// readSecret reads a secret from the environment variable env, or else from
+// file. The secret itself is never part of the returned errors.
+func readSecret(env, file string) (string, error) {
+	if env != "" {
+		secret, ok := os.LookupEnv(env)
+		if !ok || secret == "" {
+			return "", fmt.Errorf("environment variable %s is not set", env)
+		}
+		return secret, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", file)
+	}
+
+	return secret, nil
+}
+
+func splitList(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
@@ -2,12 +2,20 @@ package config
 
 import (
 	"context"
+	"errors"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
-	aserto "github.com/aserto-dev/aserto-go/client"
-	"github.com/aserto-dev/aserto-go/client/authorizer"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/tracing"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/transform"
 	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
 	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
 	"github.com/aserto-dev/idp-plugin-sdk/plugin"
@@ -25,50 +33,121 @@ func GetVersion() (string, string, string) {
 }
 
 type AsertoConfig struct {
-	Authorizer      string `description:"Aserto authorizer endpoint" kind:"attribute" mode:"normal" readonly:"false" name:"authorizer"`
-	Tenant          string `description:"Aserto Tenant ID" kind:"attribute" mode:"normal" readonly:"false" name:"tenant"`
-	APIKey          string `description:"Aserto API Key" kind:"attribute" mode:"normal" readonly:"false" name:"api-key"`
-	SplitExtensions bool   `description:"Split user and extensions" kind:"attribute" mode:"normal" readonly:"false" name:"split-extensions"`
-	Insecure        bool   `description:"Disable TLS verification if true" kind:"attribute" mode:"normal" readonly:"false" name:"insecure"`
+	Authorizer             string `description:"Aserto authorizer endpoint" kind:"attribute" mode:"normal" readonly:"false" name:"authorizer"`
+	Tenant                 string `description:"Aserto Tenant ID" kind:"attribute" mode:"normal" readonly:"false" name:"tenant"`
+	APIKey                 string `description:"Aserto API Key, or a reference to it as env:VAR, file:/path or exec:command" kind:"attribute" mode:"normal" readonly:"false" name:"api-key"`
+	SplitExtensions        bool   `description:"Split user and extensions" kind:"attribute" mode:"normal" readonly:"false" name:"split-extensions"`
+	Insecure               bool   `description:"Disable TLS verification if true" kind:"attribute" mode:"normal" readonly:"false" name:"insecure"`
+	GenerateIDs            bool   `description:"Derive user IDs from the tenant ID and PID identity" kind:"attribute" mode:"normal" readonly:"false" name:"generate-ids"`
+	MergeIdentities        bool   `description:"Merge users sharing a verified email or phone identity" kind:"attribute" mode:"normal" readonly:"false" name:"merge-identities"`
+	MergePrecedence        string `description:"Which side wins merge conflicts: incoming or existing" kind:"attribute" mode:"normal" readonly:"false" name:"merge-precedence"`
+	TransformFile          string `description:"Path to a YAML or JSON file with user transform rules" kind:"attribute" mode:"normal" readonly:"false" name:"transform-file"`
+	RoleMapping            string `description:"Inline JSON or path to a YAML or JSON file mapping groups to roles" kind:"attribute" mode:"normal" readonly:"false" name:"role-mapping"`
+	RoleMappingMode        string `description:"How mapped roles combine with existing ones: merge or replace" kind:"attribute" mode:"normal" readonly:"false" name:"role-mapping-mode"`
+	SplitApplications      string `description:"Comma separated applications moved into extensions, all if empty" kind:"attribute" mode:"normal" readonly:"false" name:"split-extensions-applications"`
+	SplitAttributes        string `description:"Comma separated attribute sections (roles, permissions, properties or none) moved into extensions, all if empty" kind:"attribute" mode:"normal" readonly:"false" name:"split-extensions-attributes"`
+	SplitProvider          string `description:"Only key extensions by PID identities of this provider" kind:"attribute" mode:"normal" readonly:"false" name:"split-extensions-provider"`
+	SplitKeepBase          bool   `description:"Keep extension fields on the base user when splitting" kind:"attribute" mode:"normal" readonly:"false" name:"split-extensions-keep-base"`
+	File                   string `description:"Path to a JSON Lines file used instead of the authorizer; writes replace its contents" kind:"attribute" mode:"normal" readonly:"false" name:"file"`
+	Timeout                int    `description:"Timeout in seconds for each call to the authorizer, none if 0" kind:"attribute" mode:"normal" readonly:"false" name:"timeout"`
+	OperationTimeout       int    `description:"Timeout in seconds for the whole import, export or delete, none if 0" kind:"attribute" mode:"normal" readonly:"false" name:"operation-timeout"`
+	CACertPath             string `description:"Path to a PEM bundle of CAs trusted for the authorizer" kind:"attribute" mode:"normal" readonly:"false" name:"ca-cert-path"`
+	ClientCertPath         string `description:"Path to a PEM client certificate for mutual TLS" kind:"attribute" mode:"normal" readonly:"false" name:"client-cert-path"`
+	ClientKeyPath          string `description:"Path to the PEM key of the client certificate" kind:"attribute" mode:"normal" readonly:"false" name:"client-key-path"`
+	ServerNameOverride     string `description:"Server name expected in the authorizer certificate" kind:"attribute" mode:"normal" readonly:"false" name:"server-name-override"`
+	AuthMethod             string `description:"How to authenticate with the authorizer: api-key, token or oauth2" kind:"attribute" mode:"normal" readonly:"false" name:"auth-method"`
+	TokenEnv               string `description:"Environment variable holding the bearer token" kind:"attribute" mode:"normal" readonly:"false" name:"token-env"`
+	TokenFile              string `description:"Path to a file holding the bearer token" kind:"attribute" mode:"normal" readonly:"false" name:"token-file"`
+	OAuth2TokenURL         string `description:"OAuth2 token endpoint for the client credentials grant" kind:"attribute" mode:"normal" readonly:"false" name:"oauth2-token-url"`
+	OAuth2ClientID         string `description:"OAuth2 client ID" kind:"attribute" mode:"normal" readonly:"false" name:"oauth2-client-id"`
+	OAuth2ClientSecretEnv  string `description:"Environment variable holding the OAuth2 client secret" kind:"attribute" mode:"normal" readonly:"false" name:"oauth2-client-secret-env"`
+	OAuth2ClientSecretFile string `description:"Path to a file holding the OAuth2 client secret" kind:"attribute" mode:"normal" readonly:"false" name:"oauth2-client-secret-file"`
+	OAuth2Scopes           string `description:"Comma separated OAuth2 scopes to request" kind:"attribute" mode:"normal" readonly:"false" name:"oauth2-scopes"`
+	OAuth2Audience         string `description:"OAuth2 audience to request" kind:"attribute" mode:"normal" readonly:"false" name:"oauth2-audience"`
+	TenantsFile            string `description:"Path to a YAML or JSON file listing tenants to write to, each with its own attributes" kind:"attribute" mode:"normal" readonly:"false" name:"tenants-file"`
+	Diagnostics            bool   `description:"Check DNS, TCP, TLS, credentials, tenant and permissions step by step when validating" kind:"attribute" mode:"normal" readonly:"false" name:"diagnostics"`
+	KeepaliveTime          int    `description:"Seconds without activity after which the connection is pinged, no pings if 0" kind:"attribute" mode:"normal" readonly:"false" name:"keepalive-time"`
+	KeepaliveTimeout       int    `description:"Seconds to wait for a ping to be answered before closing the connection" kind:"attribute" mode:"normal" readonly:"false" name:"keepalive-timeout"`
+	Gzip                   bool   `description:"Compress calls and streams with gzip" kind:"attribute" mode:"normal" readonly:"false" name:"gzip"`
+	MaxSendMessageSize     int    `description:"Largest message in bytes sent to the authorizer, the gRPC default if 0" kind:"attribute" mode:"normal" readonly:"false" name:"max-send-message-size"`
+	MaxReceiveMessageSize  int    `description:"Largest message in bytes received from the authorizer, the gRPC default if 0" kind:"attribute" mode:"normal" readonly:"false" name:"max-receive-message-size"`
+	Transport              string `description:"How to call the authorizer: grpc, or rest through its HTTPS gateway" kind:"attribute" mode:"normal" readonly:"false" name:"transport"`
+	ProxyURL               string `description:"HTTP proxy to reach the authorizer through, HTTPS_PROXY if empty" kind:"attribute" mode:"normal" readonly:"false" name:"proxy-url"`
+	ProxyUsername          string `description:"Username to authenticate with the proxy" kind:"attribute" mode:"normal" readonly:"false" name:"proxy-username"`
+	ProxyPassword          string `description:"Password to authenticate with the proxy, or a reference to it as env:VAR, file:/path or exec:command" kind:"attribute" mode:"normal" readonly:"false" name:"proxy-password"`
+	MetricsAddr            string `description:"Address to serve Prometheus metrics on at /metrics during the operation, such as 127.0.0.1:9464" kind:"attribute" mode:"normal" readonly:"false" name:"metrics-addr"`
+	MetricsFile            string `description:"Path of a file to write Prometheus metrics to when the operation is closed, for the node exporter textfile collector" kind:"attribute" mode:"normal" readonly:"false" name:"metrics-file"`
+	TracingExporter        string `description:"Where to export OpenTelemetry spans to: otlp, stdout or file, none if empty" kind:"attribute" mode:"normal" readonly:"false" name:"tracing-exporter"`
+	TracingEndpoint        string `description:"host:port of the OTLP collector, OTEL_EXPORTER_OTLP_ENDPOINT if empty" kind:"attribute" mode:"normal" readonly:"false" name:"tracing-endpoint"`
+	TracingInsecure        bool   `description:"Send spans to the OTLP collector without TLS" kind:"attribute" mode:"normal" readonly:"false" name:"tracing-insecure"`
+	TracingFile            string `description:"Path of a file spans are appended to as JSON by the file exporter" kind:"attribute" mode:"normal" readonly:"false" name:"tracing-file"`
+	ProgressInterval       int    `description:"Seconds between progress reports of the users read and written, none if 0" kind:"attribute" mode:"normal" readonly:"false" name:"progress-interval"`
+	ProgressTotal          int    `description:"Number of users expected to be written or deleted, to estimate the time remaining" kind:"attribute" mode:"normal" readonly:"false" name:"progress-total"`
+	ProgressCount          bool   `description:"Count the users before reading them, to estimate the time remaining" kind:"attribute" mode:"normal" readonly:"false" name:"progress-count"`
+	ReportFile             string `description:"Path of a file to write a JSON summary of the operation to when it is closed" kind:"attribute" mode:"normal" readonly:"false" name:"report-file"`
 }
 
+const (
+	MergePrecedenceIncoming = "incoming"
+	MergePrecedenceExisting = "existing"
+)
+
+const (
+	AuthMethodAPIKey = "api-key"
+	AuthMethodToken  = "token"
+	AuthMethodOAuth2 = "oauth2"
+)
+
+const (
+	TransportGRPC = "grpc"
+	TransportREST = "rest"
+)
+
 func (c *AsertoConfig) Validate(operation plugin.OperationType) error {
+	if err := c.validateOptions(); err != nil {
+		return err
+	}
+
+	if c.TenantsFile != "" {
+		return c.validateTenants(operation)
+	}
+
+	if c.File != "" {
+		return c.validateFile(operation)
+	}
 
 	if c.Authorizer == "" {
 		return status.Error(codes.InvalidArgument, "no authorizer was provided")
 	}
 
-	if c.APIKey == "" && !c.Insecure {
-		return status.Error(codes.InvalidArgument, "no api key was provided")
+	if err := c.validateAuth(); err != nil {
+		return err
 	}
 
 	if c.Tenant == "" {
 		return status.Error(codes.InvalidArgument, "no tenant was provided")
 	}
 
+	if c.Diagnostics {
+		return c.validateWithDiagnostics(operation)
+	}
+
 	ctx := context.Background()
-	var client *authorizer.Client
-	var err error
-
-	if c.Insecure {
-		client, err = authorizer.New(
-			ctx,
-			aserto.WithAddr(c.Authorizer),
-			aserto.WithTenantID(c.Tenant),
-			aserto.WithInsecure(c.Insecure),
-		)
-	} else {
-		client, err = authorizer.New(
-			ctx,
-			aserto.WithAddr(c.Authorizer),
-			aserto.WithAPIKeyAuth(c.APIKey),
-			aserto.WithTenantID(c.Tenant),
-		)
+	if timeout := c.RPCTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
 
+	client, err := c.Connect(ctx)
 	if err != nil {
+		var optsErr *OptionsError
+		if errors.As(err, &optsErr) {
+			return status.Errorf(codes.InvalidArgument, "invalid connection options: %s", err.Error())
+		}
 		return status.Errorf(codes.Internal, "failed to create authorizer connection %s", err.Error())
 	}
+	defer client.Close()
 
 	_, err = client.Directory.ListUsers(ctx, &dir.ListUsersRequest{
 		Page: &api.PaginationRequest{
@@ -81,6 +160,163 @@ func (c *AsertoConfig) Validate(operation plugin.OperationType) error {
 	if err != nil {
 		return status.Errorf(codes.Internal, "failed to get one user: %s", err.Error())
 	}
+
+	// Imports and deletes load users, which read-only credentials cannot do.
+	if operation == plugin.OperationTypeWrite || operation == plugin.OperationTypeDelete {
+		if err := probeWrite(ctx, client.Directory); err != nil {
+			if status.Code(err) == codes.PermissionDenied || status.Code(err) == codes.Unauthenticated {
+				return status.Errorf(codes.PermissionDenied, "the credentials cannot write users to tenant %s: %s", c.Tenant, err.Error())
+			}
+			return status.Errorf(codes.Internal, "failed to open and close a load users stream: %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// RPCTimeout is the deadline of each call to the authorizer, zero if unbounded.
+func (c *AsertoConfig) RPCTimeout() time.Duration {
+	return time.Duration(c.Timeout) * time.Second
+}
+
+// OperationDeadline is the deadline of a whole operation, zero if unbounded.
+func (c *AsertoConfig) OperationDeadline() time.Duration {
+	return time.Duration(c.OperationTimeout) * time.Second
+}
+
+// ProgressReportInterval is the time between progress reports, zero if
+// progress is not reported.
+func (c *AsertoConfig) ProgressReportInterval() time.Duration {
+	return time.Duration(c.ProgressInterval) * time.Second
+}
+
+// validateWithDiagnostics logs the outcome of every diagnostics check and
+// fails with the hints of the failed ones.
+func (c *AsertoConfig) validateWithDiagnostics(operation plugin.OperationType) error {
+	d := c.Diagnose(context.Background(), operation)
+
+	var report strings.Builder
+	_ = d.WriteText(&report)
+	for _, line := range strings.Split(strings.TrimSpace(report.String()), "\n") {
+		log.Printf("diagnostics: %s", line)
+	}
+
+	return d.Err()
+}
+
+func (c *AsertoConfig) validateOptions() error {
+	if c.Timeout < 0 {
+		return status.Errorf(codes.InvalidArgument, "invalid timeout %d", c.Timeout)
+	}
+
+	if c.OperationTimeout < 0 {
+		return status.Errorf(codes.InvalidArgument, "invalid operation timeout %d", c.OperationTimeout)
+	}
+
+	for _, option := range []struct {
+		name  string
+		value int
+	}{
+		{"keepalive time", c.KeepaliveTime},
+		{"keepalive timeout", c.KeepaliveTimeout},
+		{"max send message size", c.MaxSendMessageSize},
+		{"max receive message size", c.MaxReceiveMessageSize},
+		{"progress interval", c.ProgressInterval},
+		{"progress total", c.ProgressTotal},
+	} {
+		if option.value < 0 {
+			return status.Errorf(codes.InvalidArgument, "invalid %s %d", option.name, option.value)
+		}
+	}
+
+	switch c.Transport {
+	case "", TransportGRPC, TransportREST:
+	default:
+		return status.Errorf(codes.InvalidArgument, "invalid transport %q", c.Transport)
+	}
+
+	if c.ProxyURL != "" {
+		if u, err := url.Parse(c.ProxyURL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return status.Errorf(codes.InvalidArgument, "invalid proxy url %q", c.ProxyURL)
+		}
+	}
+
+	if c.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddr); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid metrics address: %s", err.Error())
+		}
+	}
+
+	if c.MetricsFile != "" {
+		if _, err := os.Stat(filepath.Dir(c.MetricsFile)); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid metrics file: %s", err.Error())
+		}
+	}
+
+	if c.ReportFile != "" {
+		if _, err := os.Stat(filepath.Dir(c.ReportFile)); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid report file: %s", err.Error())
+		}
+	}
+
+	switch c.TracingExporter {
+	case "", tracing.ExporterOTLP, tracing.ExporterStdout:
+	case tracing.ExporterFile:
+		if c.TracingFile == "" {
+			return status.Error(codes.InvalidArgument, "no tracing file was provided")
+		}
+		if _, err := os.Stat(filepath.Dir(c.TracingFile)); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid tracing file: %s", err.Error())
+		}
+	default:
+		return status.Errorf(codes.InvalidArgument, "invalid tracing exporter %q", c.TracingExporter)
+	}
+
+	switch c.MergePrecedence {
+	case "", MergePrecedenceIncoming, MergePrecedenceExisting:
+	default:
+		return status.Errorf(codes.InvalidArgument, "invalid merge precedence %q", c.MergePrecedence)
+	}
+
+	for _, section := range strings.Split(c.SplitAttributes, ",") {
+		switch strings.TrimSpace(section) {
+		case "", "none", "roles", "permissions", "properties":
+		default:
+			return status.Errorf(codes.InvalidArgument, "invalid split extensions attribute %q", section)
+		}
+	}
+
+	if c.TransformFile != "" {
+		if _, err := transform.Load(c.TransformFile); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid transform file: %s", err.Error())
+		}
+	}
+
+	if c.RoleMapping != "" {
+		if _, err := transform.LoadRoleMap(c.RoleMapping); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid role mapping: %s", err.Error())
+		}
+	}
+
+	switch c.RoleMappingMode {
+	case "", transform.RoleMappingMerge, transform.RoleMappingReplace:
+	default:
+		return status.Errorf(codes.InvalidArgument, "invalid role mapping mode %q", c.RoleMappingMode)
+	}
+
+	return nil
+}
+
+func (c *AsertoConfig) validateFile(operation plugin.OperationType) error {
+	path := c.File
+	if operation == plugin.OperationTypeWrite {
+		path = filepath.Dir(c.File)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid file: %s", err.Error())
+	}
+
 	return nil
 }
 
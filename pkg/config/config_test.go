@@ -1,8 +1,15 @@
 package config
 
 import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/proxy"
 	"github.com/aserto-dev/idp-plugin-sdk/plugin"
 	"github.com/stretchr/testify/require"
 )
@@ -34,6 +41,56 @@ func TestValidateWithEmptyAPIKey(t *testing.T) {
 	assert.Equal("rpc error: code = InvalidArgument desc = no api key was provided", err.Error())
 }
 
+func TestCredentialsWithInsecureEmptyAPIKey(t *testing.T) {
+	assert := require.New(t)
+	config := AsertoConfig{
+		Authorizer: "localhost:8282",
+		Tenant:     "tenantID",
+		AuthMethod: AuthMethodAPIKey,
+		Insecure:   true,
+	}
+
+	creds, err := config.credentials(&proxy.Dialer{}, nil)
+	assert.NoError(err)
+	assert.Nil(creds)
+}
+
+func TestCredentialsOAuth2WithCACert(t *testing.T) {
+	assert := require.New(t)
+	tokenServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"oauth2-token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tokenServer.Certificate().Raw})
+	assert.NoError(os.WriteFile(caCert, certPEM, 0600))
+	t.Setenv("TEST_OAUTH2_SECRET", "secret")
+
+	config := AsertoConfig{
+		Authorizer:            "localhost:8282",
+		Tenant:                "tenantID",
+		AuthMethod:            AuthMethodOAuth2,
+		OAuth2TokenURL:        tokenServer.URL,
+		OAuth2ClientID:        "client",
+		OAuth2ClientSecretEnv: "TEST_OAUTH2_SECRET",
+		CACertPath:            caCert,
+		ServerNameOverride:    "authorizer.unit.com",
+	}
+
+	dialer, err := config.proxyDialer()
+	assert.NoError(err)
+	tlsConf, err := config.tlsConfig()
+	assert.NoError(err)
+
+	creds, err := config.credentials(dialer, tlsConf)
+	assert.NoError(err)
+	md, err := creds.GetRequestMetadata(context.Background())
+	assert.NoError(err)
+	assert.Equal("bearer oauth2-token", md["authorization"])
+}
+
 func TestValidateWithEmptyTenantID(t *testing.T) {
 	assert := require.New(t)
 	config := AsertoConfig{
@@ -48,6 +105,34 @@ func TestValidateWithEmptyTenantID(t *testing.T) {
 	assert.Equal("rpc error: code = InvalidArgument desc = no tenant was provided", err.Error())
 }
 
+func TestValidateWithInvalidMergePrecedence(t *testing.T) {
+	assert := require.New(t)
+	config := AsertoConfig{
+		Authorizer:      "Auth",
+		APIKey:          "APIKey",
+		Tenant:          "tenantID",
+		MergePrecedence: "newest",
+	}
+
+	err := config.Validate(plugin.OperationTypeWrite)
+
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = invalid merge precedence \"newest\"", err.Error())
+}
+
+func TestValidateWithFile(t *testing.T) {
+	assert := require.New(t)
+	config := AsertoConfig{
+		File: filepath.Join(t.TempDir(), "users.jsonl"),
+	}
+
+	assert.Nil(config.Validate(plugin.OperationTypeWrite))
+
+	err := config.Validate(plugin.OperationTypeRead)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "rpc error: code = InvalidArgument desc = invalid file:")
+}
+
 func TestValidateWithInvalidCredentials(t *testing.T) {
 	assert := require.New(t)
 	config := AsertoConfig{
@@ -74,3 +159,119 @@ func TestDecription(t *testing.T) {
 
 	assert.Equal("Aserto plugin", description, "should return the description of the plugin")
 }
+
+func TestValidateWithInvalidTimeout(t *testing.T) {
+	assert := require.New(t)
+	cfg := AsertoConfig{File: "users.jsonl", Timeout: -1}
+
+	err := cfg.Validate(plugin.OperationTypeRead)
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = invalid timeout -1", err.Error())
+}
+
+func TestValidateWithClientCertWithoutKey(t *testing.T) {
+	assert := require.New(t)
+	cfg := AsertoConfig{Authorizer: "localhost:8282", Tenant: "tenant", APIKey: "key", ClientCertPath: "client.pem"}
+
+	err := cfg.Validate(plugin.OperationTypeRead)
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = invalid connection options: client-cert-path and client-key-path must be set together", err.Error())
+}
+
+func TestValidateWithInvalidCACert(t *testing.T) {
+	assert := require.New(t)
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(os.WriteFile(caCert, []byte("not a certificate"), 0600))
+	cfg := AsertoConfig{Authorizer: "localhost:8282", Tenant: "tenant", APIKey: "key", CACertPath: caCert}
+
+	err := cfg.Validate(plugin.OperationTypeRead)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "no certificates found in")
+}
+
+func TestValidateWithTokenWithoutSource(t *testing.T) {
+	assert := require.New(t)
+	cfg := AsertoConfig{Authorizer: "localhost:8282", Tenant: "tenant", AuthMethod: AuthMethodToken}
+
+	err := cfg.Validate(plugin.OperationTypeRead)
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = exactly one of token-env and token-file must be provided", err.Error())
+}
+
+func TestValidateWithUnsetTokenEnv(t *testing.T) {
+	assert := require.New(t)
+	t.Setenv("ASERTO_TEST_TOKEN", "")
+	cfg := AsertoConfig{Authorizer: "localhost:8282", Tenant: "tenant", AuthMethod: AuthMethodToken, TokenEnv: "ASERTO_TEST_TOKEN"}
+
+	err := cfg.Validate(plugin.OperationTypeRead)
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = invalid connection options: read token: environment variable ASERTO_TEST_TOKEN is not set", err.Error())
+}
+
+func TestValidateWithInvalidMessageSize(t *testing.T) {
+	assert := require.New(t)
+	cfg := AsertoConfig{File: "users.jsonl", MaxSendMessageSize: -1}
+
+	err := cfg.Validate(plugin.OperationTypeRead)
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = invalid max send message size -1", err.Error())
+}
+
+func TestValidateWithInvalidTransport(t *testing.T) {
+	assert := require.New(t)
+	cfg := AsertoConfig{File: "users.jsonl", Transport: "http3"}
+
+	err := cfg.Validate(plugin.OperationTypeRead)
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = invalid transport \"http3\"", err.Error())
+}
+
+func TestValidateWithInvalidProxyURL(t *testing.T) {
+	assert := require.New(t)
+	cfg := AsertoConfig{File: "users.jsonl", ProxyURL: "socks5://proxy.unit.com:1080"}
+
+	err := cfg.Validate(plugin.OperationTypeRead)
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = invalid proxy url \"socks5://proxy.unit.com:1080\"", err.Error())
+}
+
+func TestValidateWithInvalidMetricsAddr(t *testing.T) {
+	assert := require.New(t)
+	cfg := AsertoConfig{File: "users.jsonl", MetricsAddr: "9464"}
+
+	err := cfg.Validate(plugin.OperationTypeRead)
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = invalid metrics address: address 9464: missing port in address", err.Error())
+}
+
+func TestValidateWithInvalidTracingExporter(t *testing.T) {
+	assert := require.New(t)
+	cfg := AsertoConfig{File: "users.jsonl", TracingExporter: "jaeger"}
+
+	err := cfg.Validate(plugin.OperationTypeRead)
+	assert.NotNil(err)
+	assert.Equal(`rpc error: code = InvalidArgument desc = invalid tracing exporter "jaeger"`, err.Error())
+
+	cfg.TracingExporter = "file"
+	err = cfg.Validate(plugin.OperationTypeRead)
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = no tracing file was provided", err.Error())
+}
+
+func TestValidateWithInvalidProgressInterval(t *testing.T) {
+	assert := require.New(t)
+	cfg := AsertoConfig{File: "users.jsonl", ProgressInterval: -1}
+
+	err := cfg.Validate(plugin.OperationTypeRead)
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = invalid progress interval -1", err.Error())
+}
+
+func TestValidateWithInvalidReportFile(t *testing.T) {
+	assert := require.New(t)
+	cfg := AsertoConfig{File: "users.jsonl", ReportFile: "/does/not/exist/report.json"}
+
+	err := cfg.Validate(plugin.OperationTypeRead)
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = invalid report file: stat /does/not/exist: no such file or directory", err.Error())
+}
@@ -0,0 +1,301 @@
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	aserto "github.com/aserto-dev/aserto-go/client"
+	"github.com/aserto-dev/aserto-go/client/authorizer"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/proxy"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/rest"
+	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
+)
+
+// ConnectionOptions returns the options to connect to the authorizer
+// described by the config.
+func (c *AsertoConfig) ConnectionOptions() ([]aserto.ConnectionOption, error) {
+	dialer, err := c.proxyDialer()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.connectionOptions(dialer)
+}
+
+// connectionOptions returns the options to connect through dialer.
+func (c *AsertoConfig) connectionOptions(dialer *proxy.Dialer) ([]aserto.ConnectionOption, error) {
+	tlsConf, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []aserto.ConnectionOption{
+		aserto.WithAddr(c.Authorizer),
+		aserto.WithTenantID(c.Tenant),
+		aserto.WithDialOptions(c.dialOptions(dialer)...),
+	}
+
+	if c.Insecure {
+		opts = append(opts, aserto.WithInsecure(c.Insecure))
+	}
+
+	authOpt, err := c.authOption(dialer, tlsConf)
+	if err != nil {
+		return nil, err
+	}
+	if authOpt != nil {
+		opts = append(opts, authOpt)
+	}
+
+	// The client always dials with TLS credentials of its own; options added
+	// later take precedence over them.
+	if tlsConf != nil {
+		opts = append(opts, aserto.WithDialOptions(grpc.WithTransportCredentials(credentials.NewTLS(tlsConf))))
+	}
+
+	return opts, nil
+}
+
+// dialOptions returns the proxy, user agent, keepalive, compression and
+// message size settings of the connection.
+func (c *AsertoConfig) dialOptions(dialer *proxy.Dialer) []grpc.DialOption {
+	opts := []grpc.DialOption{
+		grpc.WithContextDialer(dialer.DialContext),
+		grpc.WithUserAgent(userAgent()),
+	}
+
+	if c.KeepaliveTime > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                time.Duration(c.KeepaliveTime) * time.Second,
+			Timeout:             time.Duration(c.KeepaliveTimeout) * time.Second,
+			PermitWithoutStream: true,
+		}))
+	}
+
+	var callOpts []grpc.CallOption
+	if c.Gzip {
+		callOpts = append(callOpts, grpc.UseCompressor(gzip.Name))
+	}
+	if c.MaxSendMessageSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallSendMsgSize(c.MaxSendMessageSize))
+	}
+	if c.MaxReceiveMessageSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallRecvMsgSize(c.MaxReceiveMessageSize))
+	}
+	if len(callOpts) > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(callOpts...))
+	}
+
+	return opts
+}
+
+// proxyDialer returns the dialer connecting through the configured proxy, or
+// the one of the environment.
+func (c *AsertoConfig) proxyDialer() (*proxy.Dialer, error) {
+	dialer := &proxy.Dialer{
+		URL:       c.ProxyURL,
+		Username:  c.ProxyUsername,
+		UserAgent: userAgent(),
+	}
+
+	if c.ProxyPassword != "" {
+		password, err := resolveSecret(c.ProxyPassword)
+		if err != nil {
+			return nil, fmt.Errorf("resolve proxy password: %w", err)
+		}
+		dialer.Password = password
+	}
+
+	return dialer, nil
+}
+
+// tlsConfig returns the TLS settings for a custom CA, client certificate or
+// server name, or nil when the defaults apply.
+func (c *AsertoConfig) tlsConfig() (*tls.Config, error) {
+	if c.CACertPath == "" && c.ClientCertPath == "" && c.ClientKeyPath == "" && c.ServerNameOverride == "" {
+		return nil, nil
+	}
+
+	conf := &tls.Config{
+		ServerName:         c.ServerNameOverride,
+		InsecureSkipVerify: c.Insecure, // nolint:gosec // explicitly requested
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if c.CACertPath != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		caCert, err := os.ReadFile(c.CACertPath)
+		if err != nil {
+			return nil, fmt.Errorf("read ca cert: %w", err)
+		}
+
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CACertPath)
+		}
+
+		conf.RootCAs = pool
+	}
+
+	if c.ClientCertPath != "" || c.ClientKeyPath != "" {
+		if c.ClientCertPath == "" || c.ClientKeyPath == "" {
+			return nil, fmt.Errorf("client-cert-path and client-key-path must be set together")
+		}
+
+		cert, err := tls.LoadX509KeyPair(c.ClientCertPath, c.ClientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("load client cert: %w", err)
+		}
+
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	return conf, nil
+}
+
+// Connection is an open connection to the directory of the authorizer. It
+// must be closed once done with.
+type Connection struct {
+	Directory dir.DirectoryClient
+
+	closer io.Closer
+}
+
+// OptionsError reports connection options of the config that could not be
+// resolved, such as an unreadable certificate or a failing secret reference,
+// as opposed to a failure to reach the authorizer.
+type OptionsError struct {
+	Err error
+}
+
+func (e *OptionsError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *OptionsError) Unwrap() error {
+	return e.Err
+}
+
+// Connect opens a connection to the authorizer described by the config,
+// through the configured transport. Additional options are applied after the
+// ones derived from the config and only apply to gRPC connections. Options
+// that cannot be resolved are reported as an *OptionsError.
+func (c *AsertoConfig) Connect(ctx context.Context, opts ...aserto.ConnectionOption) (*Connection, error) {
+	dialer, err := c.proxyDialer()
+	if err != nil {
+		return nil, &OptionsError{Err: err}
+	}
+
+	return c.connect(ctx, dialer, opts...)
+}
+
+// connect opens a connection like Connect, through dialer.
+func (c *AsertoConfig) connect(ctx context.Context, dialer *proxy.Dialer, opts ...aserto.ConnectionOption) (*Connection, error) {
+	if c.Transport == TransportREST {
+		conn, err := c.connectREST(dialer)
+		if err != nil {
+			return nil, &OptionsError{Err: err}
+		}
+		return conn, nil
+	}
+
+	confOpts, err := c.connectionOptions(dialer)
+	if err != nil {
+		return nil, &OptionsError{Err: err}
+	}
+
+	client, err := authorizer.New(ctx, append(confOpts, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &Connection{Directory: client.Directory}
+	if closer, ok := client.Connection().(io.Closer); ok {
+		conn.closer = closer
+	}
+
+	return conn, nil
+}
+
+// connectREST returns a connection calling the HTTPS gateway of the
+// authorizer instead of its gRPC service.
+func (c *AsertoConfig) connectREST(dialer *proxy.Dialer) (*Connection, error) {
+	tlsConf, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := c.credentials(dialer, tlsConf)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConf == nil && c.Insecure {
+		tlsConf = &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS12} // nolint:gosec // explicitly requested
+	}
+
+	client, err := rest.New(&rest.Config{
+		URL:         c.restURL(),
+		TenantID:    c.Tenant,
+		Credentials: creds,
+		TLSConfig:   tlsConf,
+		Proxy:       dialer.Proxy,
+		UserAgent:   userAgent(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Connection{Directory: client, closer: client}, nil
+}
+
+// restURL is the base URL of the gateway. An authorizer given as host:port
+// is reached over HTTPS.
+func (c *AsertoConfig) restURL() string {
+	if strings.Contains(c.Authorizer, "://") {
+		return c.Authorizer
+	}
+
+	return "https://" + c.Authorizer
+}
+
+// hostAddress is the host, and port if any, of the authorizer.
+func (c *AsertoConfig) hostAddress() string {
+	if u, err := url.Parse(c.Authorizer); err == nil && u.Host != "" {
+		return u.Host
+	}
+
+	return c.Authorizer
+}
+
+// Close closes the underlying connection.
+func (c *Connection) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+
+	return c.closer.Close()
+}
+
+// userAgent identifies the plugin and its version to the authorizer.
+func userAgent() string {
+	version := ver
+	if version == "" {
+		version = "dev"
+	}
+
+	return "aserto-idp-plugin-aserto/" + version
+}
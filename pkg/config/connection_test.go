@@ -0,0 +1,118 @@
+package config
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/fakedir"
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestConnect(t *testing.T) {
+	assert := require.New(t)
+	server, cfg := diagnosticsConfig(t)
+
+	conn, err := cfg.Connect(context.Background())
+	assert.NoError(err)
+
+	req := &dir.ListUsersRequest{Page: &api.PaginationRequest{Size: 1}}
+	_, err = conn.Directory.ListUsers(context.Background(), req)
+	assert.NoError(err)
+	assert.True(strings.HasPrefix(server.UserAgent(), "aserto-idp-plugin-aserto/"), server.UserAgent())
+
+	assert.NoError(conn.Close())
+	_, err = conn.Directory.ListUsers(context.Background(), req)
+	assert.Equal(codes.Canceled, status.Code(err))
+}
+
+func TestConnectREST(t *testing.T) {
+	assert := require.New(t)
+	server, cfg := diagnosticsConfig(t)
+
+	url, err := server.StartGateway()
+	assert.NoError(err)
+	cfg.Authorizer = url
+	cfg.Transport = TransportREST
+
+	conn, err := cfg.Connect(context.Background())
+	assert.NoError(err)
+	defer conn.Close()
+
+	_, err = conn.Directory.ListUsers(context.Background(), &dir.ListUsersRequest{Page: &api.PaginationRequest{Size: 1}})
+	assert.NoError(err)
+	assert.True(strings.HasPrefix(server.UserAgent(), "aserto-idp-plugin-aserto/"), server.UserAgent())
+
+	d := cfg.Diagnose(context.Background(), plugin.OperationTypeWrite)
+	assert.NoError(d.Err())
+	assert.Equal("connected to "+strings.TrimPrefix(url, "https://"), d.Checks[1].Detail)
+}
+
+func TestRESTURL(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("https://authorizer.unit.com:8443", (&AsertoConfig{Authorizer: "authorizer.unit.com:8443"}).restURL())
+	assert.Equal("http://localhost:8383/", (&AsertoConfig{Authorizer: "http://localhost:8383/"}).restURL())
+	assert.Equal("authorizer.unit.com:8443", (&AsertoConfig{Authorizer: "https://authorizer.unit.com:8443"}).hostAddress())
+	assert.Equal("127.0.0.1:8443", (&AsertoConfig{Authorizer: "127.0.0.1:8443"}).hostAddress())
+}
+
+func TestConnectThroughProxy(t *testing.T) {
+	assert := require.New(t)
+	server, cfg := diagnosticsConfig(t)
+	t.Setenv("TEST_PROXY_PASSWORD", "secret")
+
+	proxy := fakedir.NewProxy("user", "secret")
+	defer proxy.Close()
+
+	_, port, err := net.SplitHostPort(cfg.Authorizer)
+	assert.NoError(err)
+	cfg.Authorizer = "authorizer.unit.com:" + port
+	cfg.ServerNameOverride = "localhost"
+	cfg.ProxyURL = proxy.URL
+	cfg.ProxyUsername = "user"
+	cfg.ProxyPassword = "env:TEST_PROXY_PASSWORD"
+
+	conn, err := cfg.Connect(context.Background())
+	assert.NoError(err)
+	defer conn.Close()
+
+	_, err = conn.Directory.ListUsers(context.Background(), &dir.ListUsersRequest{Page: &api.PaginationRequest{Size: 1}})
+	assert.NoError(err)
+	assert.Equal([]string{cfg.Authorizer}, proxy.Targets())
+
+	d := cfg.Diagnose(context.Background(), plugin.OperationTypeWrite)
+	assert.NoError(d.Err())
+	assert.Contains(d.Checks[0].Detail, "resolved by proxy")
+	assert.Equal("connected to "+cfg.Authorizer+" through proxy "+strings.Replace(proxy.URL, "http://", "http://user:xxxxx@", 1), d.Checks[1].Detail)
+
+	url, err := server.StartGateway()
+	assert.NoError(err)
+	_, port, err = net.SplitHostPort(strings.TrimPrefix(url, "https://"))
+	assert.NoError(err)
+	cfg.Authorizer = "https://authorizer.unit.com:" + port
+	cfg.Transport = TransportREST
+
+	rest, err := cfg.Connect(context.Background())
+	assert.NoError(err)
+	defer rest.Close()
+
+	_, err = rest.Directory.ListUsers(context.Background(), &dir.ListUsersRequest{Page: &api.PaginationRequest{Size: 1}})
+	assert.NoError(err)
+	assert.Contains(proxy.Targets(), "authorizer.unit.com:"+port)
+
+	cfg.ProxyPassword = "wrong"
+	rejected, err := cfg.Connect(context.Background())
+	assert.NoError(err)
+	defer rejected.Close()
+
+	_, err = rejected.Directory.ListUsers(context.Background(), &dir.ListUsersRequest{Page: &api.PaginationRequest{Size: 1}})
+	assert.Equal(codes.Unavailable, status.Code(err))
+	assert.Contains(err.Error(), "Proxy Authentication Required")
+}
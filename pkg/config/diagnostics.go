@@ -0,0 +1,359 @@
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/proxy"
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	CheckPassed  = "ok"
+	CheckFailed  = "failed"
+	CheckSkipped = "skipped"
+
+	defaultPort        = "443"
+	diagnosticsTimeout = 5 * time.Second
+)
+
+// Check is the outcome of one diagnostics step. Hint tells how to fix a
+// failed check.
+type Check struct {
+	Name     string        `json:"name"`
+	Status   string        `json:"status"`
+	Detail   string        `json:"detail,omitempty"`
+	Hint     string        `json:"hint,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Diagnostics is the result of checking the connection to the authorizer
+// step by step.
+type Diagnostics struct {
+	Checks []*Check `json:"checks"`
+}
+
+// Failed returns the failed checks.
+func (d *Diagnostics) Failed() []*Check {
+	var failed []*Check
+	for _, check := range d.Checks {
+		if check.Status == CheckFailed {
+			failed = append(failed, check)
+		}
+	}
+
+	return failed
+}
+
+// Err summarizes the failed checks, or returns nil if all passed.
+func (d *Diagnostics) Err() error {
+	failed := d.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+
+	messages := make([]string, 0, len(failed))
+	for _, check := range failed {
+		messages = append(messages, fmt.Sprintf("%s: %s", check.Name, check.Hint))
+	}
+
+	return status.Errorf(codes.FailedPrecondition, "diagnostics failed: %s", strings.Join(messages, "; "))
+}
+
+// WriteText writes one line per check.
+func (d *Diagnostics) WriteText(w io.Writer) error {
+	for _, check := range d.Checks {
+		line := fmt.Sprintf("%-12s %-8s %8s  %s", check.Name, check.Status, check.Duration.Round(time.Millisecond), check.Detail)
+		if check.Hint != "" {
+			line += " (" + check.Hint + ")"
+		}
+		if _, err := fmt.Fprintln(w, strings.TrimRight(line, " ")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Diagnose checks, in order, DNS resolution, TCP reachability, the TLS
+// handshake, the credentials, the tenant and read and write permissions. A
+// check is skipped when one it depends on failed, and the write check is
+// skipped for reads.
+func (c *AsertoConfig) Diagnose(ctx context.Context, operation plugin.OperationType) *Diagnostics {
+	d := &Diagnostics{}
+
+	host, port, err := net.SplitHostPort(c.hostAddress())
+	if err != nil {
+		host, port = c.hostAddress(), defaultPort
+	}
+	addr := net.JoinHostPort(host, port)
+
+	// The proxy password may come from a command, resolve it once for all
+	// the checks.
+	dialer, dialerErr := c.proxyDialer()
+
+	ok := d.run(ctx, true, "dns", func(ctx context.Context, check *Check) {
+		if net.ParseIP(host) != nil {
+			check.Detail = host
+			return
+		}
+		if dialerErr == nil {
+			if proxyURL, err := dialer.ProxyURL(addr); err == nil && proxyURL != nil {
+				check.Detail = "resolved by proxy " + proxyURL.Redacted()
+				return
+			}
+		}
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			check.fail(err, fmt.Sprintf("host %s cannot be resolved, check the authorizer address and your DNS settings", host))
+			return
+		}
+		check.Detail = strings.Join(addrs, ", ")
+	})
+
+	ok = d.run(ctx, ok, "tcp", func(ctx context.Context, check *Check) {
+		if dialerErr != nil {
+			check.fail(dialerErr, "fix proxy-password")
+			return
+		}
+		proxyURL, err := dialer.ProxyURL(addr)
+		if err != nil {
+			check.fail(err, "fix proxy-url or the HTTPS_PROXY environment variable")
+			return
+		}
+
+		conn, err := dialer.DialContext(ctx, addr)
+		if err != nil {
+			if proxyURL != nil {
+				check.fail(err, fmt.Sprintf("%s is not reachable through proxy %s, check the proxy settings", addr, proxyURL.Redacted()))
+				return
+			}
+			check.fail(err, fmt.Sprintf("%s is not reachable, check the port and any firewall or proxy in between", addr))
+			return
+		}
+		conn.Close()
+
+		check.Detail = "connected to " + conn.RemoteAddr().String()
+		if proxyURL != nil {
+			check.Detail = fmt.Sprintf("connected to %s through proxy %s", addr, proxyURL.Redacted())
+		}
+	})
+
+	ok = d.run(ctx, ok, "tls", func(ctx context.Context, check *Check) {
+		c.diagnoseTLS(ctx, dialer, host, addr, check)
+	})
+
+	var client *Connection
+	defer func() {
+		if client != nil {
+			client.Close()
+		}
+	}()
+
+	ok = d.run(ctx, ok, "credentials", func(ctx context.Context, check *Check) {
+		var err error
+		client, err = c.connect(ctx, dialer)
+		if err != nil {
+			var optsErr *OptionsError
+			if errors.As(err, &optsErr) {
+				check.fail(err, "fix the connection options of the config")
+				return
+			}
+			check.fail(err, "the connection could not be established")
+			return
+		}
+
+		_, err = client.Directory.ListUsers(ctx, &dir.ListUsersRequest{Page: &api.PaginationRequest{Size: 1}, Base: true})
+		switch status.Code(err) {
+		case codes.OK, codes.PermissionDenied, codes.NotFound:
+			check.Detail = "accepted"
+		case codes.Unauthenticated:
+			check.fail(err, "the credentials were rejected, check api-key or auth-method")
+		default:
+			check.fail(err, "the authorizer could not verify the credentials")
+		}
+	})
+
+	ok = d.run(ctx, ok, "tenant", func(ctx context.Context, check *Check) {
+		_, err := client.Directory.ListUsers(ctx, &dir.ListUsersRequest{Page: &api.PaginationRequest{Size: 1}, Base: true})
+		switch status.Code(err) {
+		case codes.OK:
+			check.Detail = c.Tenant
+		case codes.PermissionDenied, codes.NotFound:
+			check.fail(err, fmt.Sprintf("tenant %s does not exist or does not belong to the credentials", c.Tenant))
+		default:
+			check.fail(err, "the authorizer could not look up the tenant")
+		}
+	})
+
+	readOK := d.run(ctx, ok, "read", func(ctx context.Context, check *Check) {
+		resp, err := client.Directory.ListUsers(ctx, &dir.ListUsersRequest{Page: &api.PaginationRequest{Size: 1}})
+		if err != nil {
+			check.fail(err, "the credentials cannot list users")
+			return
+		}
+		check.Detail = fmt.Sprintf("listed %d user(s)", len(resp.Results))
+	})
+
+	needsWrite := operation == plugin.OperationTypeWrite || operation == plugin.OperationTypeDelete
+	d.run(ctx, ok && needsWrite, "write", func(ctx context.Context, check *Check) {
+		check.Detail = "opened and closed a load users stream"
+		check.fail(probeWrite(ctx, client.Directory), "the credentials cannot load users, use an api key with write access")
+	})
+
+	d.run(ctx, readOK, "latency", func(ctx context.Context, check *Check) {
+		const samples = 3
+		var total time.Duration
+		for i := 0; i < samples; i++ {
+			start := time.Now()
+			if _, err := client.Directory.ListUsers(ctx, &dir.ListUsersRequest{Page: &api.PaginationRequest{Size: 1}, Base: true}); err != nil {
+				check.fail(err, "the authorizer stopped answering")
+				return
+			}
+			total += time.Since(start)
+		}
+		check.Detail = fmt.Sprintf("average round trip %s", (total / samples).Round(time.Microsecond))
+	})
+
+	return d
+}
+
+// run times check and records it, or records it as skipped when a check it
+// depends on did not pass. It returns whether the check passed.
+func (d *Diagnostics) run(ctx context.Context, ok bool, name string, fn func(ctx context.Context, check *Check)) bool {
+	check := &Check{Name: name, Status: CheckPassed}
+	d.Checks = append(d.Checks, check)
+	if !ok {
+		check.Status = CheckSkipped
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, diagnosticsTimeout)
+	defer cancel()
+
+	start := time.Now()
+	fn(ctx, check)
+	check.Duration = time.Since(start)
+
+	return check.Status == CheckPassed
+}
+
+// fail marks the check as failed with hint, unless err is nil.
+func (check *Check) fail(err error, hint string) {
+	if err == nil {
+		return
+	}
+
+	check.Status = CheckFailed
+	check.Detail = err.Error()
+	check.Hint = hint
+}
+
+func (c *AsertoConfig) diagnoseTLS(ctx context.Context, dialer *proxy.Dialer, host, addr string, check *Check) {
+	conf, err := c.tlsConfig()
+	if err != nil {
+		check.fail(err, "fix ca-cert-path, client-cert-path or client-key-path")
+		return
+	}
+	if conf == nil {
+		conf = &tls.Config{InsecureSkipVerify: c.Insecure, MinVersion: tls.VersionTLS12} // nolint:gosec // explicitly requested
+	}
+	if conf.ServerName == "" {
+		conf.ServerName = host
+	}
+
+	conn, err := dialTLS(ctx, dialer, addr, conf)
+	if err == nil {
+		state := conn.ConnectionState()
+		conn.Close()
+		check.Detail = describeCert(state.PeerCertificates)
+		if c.Insecure {
+			check.Detail += ", not verified because insecure is set"
+		}
+		return
+	}
+
+	check.fail(err, tlsHint(err, conf.ServerName))
+
+	// Connect again without verification to show what the server presents.
+	insecure := conf.Clone()
+	insecure.InsecureSkipVerify = true
+	if conn, err := dialTLS(ctx, dialer, addr, insecure); err == nil {
+		check.Detail += "; server presented " + describeCert(conn.ConnectionState().PeerCertificates)
+		conn.Close()
+	}
+}
+
+// dialTLS completes a TLS handshake with addr, through the proxy if one applies.
+func dialTLS(ctx context.Context, dialer *proxy.Dialer, addr string, conf *tls.Config) (*tls.Conn, error) {
+	raw, err := dialer.DialContext(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := tls.Client(raw, conf)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		raw.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func describeCert(chain []*x509.Certificate) string {
+	if len(chain) == 0 {
+		return "no certificate"
+	}
+
+	cert := chain[0]
+	names := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+
+	return fmt.Sprintf("certificate for %s (%s) issued by %s, expires %s",
+		cert.Subject.CommonName, strings.Join(names, ", "), cert.Issuer.CommonName, cert.NotAfter.UTC().Format(time.RFC3339))
+}
+
+func tlsHint(err error, serverName string) string {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+
+	switch {
+	case errors.As(err, &unknownAuthority):
+		return "the certificate is signed by an unknown authority, set ca-cert-path to the CA bundle of the authorizer"
+	case errors.As(err, &hostname):
+		return fmt.Sprintf("the certificate is not valid for %s, fix the authorizer address or set server-name-override", serverName)
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		return "the certificate of the authorizer is expired or not yet valid, check the clock and the certificate"
+	case strings.Contains(err.Error(), "certificate required") || strings.Contains(err.Error(), "bad certificate"):
+		return "the authorizer requires a client certificate, set client-cert-path and client-key-path"
+	case strings.Contains(err.Error(), "first record does not look like a TLS handshake"):
+		return "the authorizer does not serve TLS on this port, check the port"
+	}
+
+	return "the TLS handshake failed"
+}
+
+// probeWrite opens and closes a LoadUsers stream without sending any user.
+func probeWrite(ctx context.Context, client dir.DirectoryClient) error {
+	stream, err := client.LoadUsers(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = stream.CloseAndRecv()
+	return err
+}
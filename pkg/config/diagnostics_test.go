@@ -0,0 +1,197 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/fakedir"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	diagAPIKey = "diag-api-key"
+	diagTenant = "3dbaa470-9c4f-11ec-9a7e-0a4d3d4d7a9c"
+)
+
+func diagnosticsConfig(t *testing.T) (*fakedir.Server, *AsertoConfig) {
+	t.Helper()
+
+	server, err := fakedir.New()
+	require.NoError(t, err)
+	server.APIKey = diagAPIKey
+	server.TenantID = diagTenant
+	addr, err := server.StartTCP()
+	require.NoError(t, err)
+	t.Cleanup(server.Stop)
+
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caCert, server.CACert(), 0600))
+
+	return server, &AsertoConfig{Authorizer: addr, Tenant: diagTenant, APIKey: diagAPIKey, CACertPath: caCert}
+}
+
+func statuses(d *Diagnostics) map[string]string {
+	result := map[string]string{}
+	for _, check := range d.Checks {
+		result[check.Name] = check.Status
+	}
+
+	return result
+}
+
+func TestDiagnosePasses(t *testing.T) {
+	assert := require.New(t)
+	_, cfg := diagnosticsConfig(t)
+
+	d := cfg.Diagnose(context.Background(), plugin.OperationTypeWrite)
+	assert.NoError(d.Err())
+	assert.Len(d.Checks, 8)
+	for _, check := range d.Checks {
+		assert.Equal(CheckPassed, check.Status, check.Name)
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(d.WriteText(&buf))
+	assert.Contains(buf.String(), "certificate for localhost")
+	assert.Contains(buf.String(), "average round trip")
+}
+
+func TestDiagnoseUnknownAuthority(t *testing.T) {
+	assert := require.New(t)
+	_, cfg := diagnosticsConfig(t)
+	cfg.CACertPath = ""
+
+	d := cfg.Diagnose(context.Background(), plugin.OperationTypeWrite)
+	assert.Equal(CheckFailed, statuses(d)["tls"])
+	assert.Equal(CheckSkipped, statuses(d)["credentials"])
+	assert.Contains(d.Err().Error(), "set ca-cert-path")
+	assert.Contains(d.Checks[2].Detail, "server presented certificate for localhost")
+}
+
+func TestDiagnoseServerName(t *testing.T) {
+	assert := require.New(t)
+	_, cfg := diagnosticsConfig(t)
+	cfg.ServerNameOverride = "authorizer.example.com"
+
+	d := cfg.Diagnose(context.Background(), plugin.OperationTypeWrite)
+	assert.Equal(CheckFailed, statuses(d)["tls"])
+	assert.Contains(d.Err().Error(), "server-name-override")
+}
+
+func TestDiagnoseCredentialsAndTenant(t *testing.T) {
+	assert := require.New(t)
+	_, cfg := diagnosticsConfig(t)
+	cfg.APIKey = "wrong"
+
+	d := cfg.Diagnose(context.Background(), plugin.OperationTypeWrite)
+	assert.Equal(CheckFailed, statuses(d)["credentials"])
+	assert.Equal(CheckSkipped, statuses(d)["tenant"])
+
+	cfg.APIKey = diagAPIKey
+	cfg.Tenant = "other-tenant"
+	d = cfg.Diagnose(context.Background(), plugin.OperationTypeWrite)
+	assert.Equal(CheckPassed, statuses(d)["credentials"])
+	assert.Equal(CheckFailed, statuses(d)["tenant"])
+	assert.Contains(d.Err().Error(), "tenant other-tenant does not exist")
+}
+
+func TestDiagnoseReadOnly(t *testing.T) {
+	assert := require.New(t)
+	server, cfg := diagnosticsConfig(t)
+	server.ReadOnly = true
+
+	d := cfg.Diagnose(context.Background(), plugin.OperationTypeWrite)
+	assert.Equal(CheckPassed, statuses(d)["read"])
+	assert.Equal(CheckFailed, statuses(d)["write"])
+	assert.Contains(d.Err().Error(), "write access")
+}
+
+func TestDiagnoseUnreachable(t *testing.T) {
+	assert := require.New(t)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	addr := lis.Addr().String()
+	lis.Close()
+
+	cfg := &AsertoConfig{Authorizer: addr, Tenant: diagTenant, APIKey: diagAPIKey}
+	d := cfg.Diagnose(context.Background(), plugin.OperationTypeWrite)
+	assert.Equal(CheckPassed, statuses(d)["dns"])
+	assert.Equal(CheckFailed, statuses(d)["tcp"])
+	assert.Equal(CheckSkipped, statuses(d)["latency"])
+}
+
+func TestValidateWithDiagnostics(t *testing.T) {
+	assert := require.New(t)
+	server, cfg := diagnosticsConfig(t)
+	cfg.Diagnostics = true
+	assert.NoError(cfg.Validate(plugin.OperationTypeWrite))
+
+	server.ReadOnly = true
+	assert.NoError(cfg.Validate(plugin.OperationTypeRead))
+	err := cfg.Validate(plugin.OperationTypeWrite)
+	assert.Error(err)
+	assert.Equal("rpc error: code = FailedPrecondition desc = diagnostics failed: write: the credentials cannot load users, use an api key with write access", err.Error())
+}
+
+func TestValidateWriteProbe(t *testing.T) {
+	assert := require.New(t)
+	server, cfg := diagnosticsConfig(t)
+	assert.NoError(cfg.Validate(plugin.OperationTypeWrite))
+	assert.NoError(cfg.Validate(plugin.OperationTypeDelete))
+
+	server.ReadOnly = true
+	assert.NoError(cfg.Validate(plugin.OperationTypeRead))
+
+	err := cfg.Validate(plugin.OperationTypeWrite)
+	assert.Error(err)
+	assert.Equal("rpc error: code = PermissionDenied desc = the credentials cannot write users to tenant "+diagTenant+
+		": rpc error: code = PermissionDenied desc = read-only credentials cannot load users", err.Error())
+
+	err = cfg.Validate(plugin.OperationTypeDelete)
+	assert.Error(err)
+}
+
+func TestValidateResolvesSecretsOnce(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("relies on sh")
+	}
+	assert := require.New(t)
+	_, cfg := diagnosticsConfig(t)
+
+	proxy := fakedir.NewProxy("user", "secret")
+	defer proxy.Close()
+
+	_, port, err := net.SplitHostPort(cfg.Authorizer)
+	assert.NoError(err)
+	cfg.Authorizer = "authorizer.unit.com:" + port
+	cfg.ServerNameOverride = "localhost"
+	cfg.ProxyURL = proxy.URL
+	cfg.ProxyUsername = "user"
+
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := filepath.Join(dir, "secret.sh")
+	assert.NoError(os.WriteFile(script, []byte("#!/bin/sh\necho api-key >> "+calls+"\necho "+diagAPIKey+"\n"), 0700)) // nolint:gosec // test script
+	cfg.APIKey = "exec:" + script
+	proxyScript := filepath.Join(dir, "proxy.sh")
+	assert.NoError(os.WriteFile(proxyScript, []byte("#!/bin/sh\necho proxy-password >> "+calls+"\necho secret\n"), 0700)) // nolint:gosec // test script
+	cfg.ProxyPassword = "exec:" + proxyScript
+
+	assert.NoError(cfg.Validate(plugin.OperationTypeRead))
+	data, err := os.ReadFile(calls)
+	assert.NoError(err)
+	assert.Equal("proxy-password\napi-key\n", string(data))
+
+	assert.NoError(os.Remove(calls))
+	cfg.Diagnostics = true
+	assert.NoError(cfg.Validate(plugin.OperationTypeRead))
+	data, err = os.ReadFile(calls)
+	assert.NoError(err)
+	assert.Equal("proxy-password\napi-key\n", string(data))
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// execTimeout bounds commands run to resolve exec: secret references.
+const execTimeout = 30 * time.Second
+
+// resolveSecret returns the secret referenced by value, which is one of
+// env:VAR, file:/path or exec:command, or else the secret itself. Errors
+// describe the reference but never the resolved secret.
+func resolveSecret(value string) (string, error) {
+	kind, ref := "", ""
+	if i := strings.Index(value, ":"); i > 0 {
+		kind, ref = value[:i], value[i+1:]
+	}
+
+	switch kind {
+	case "env":
+		return readSecret(ref, "")
+	case "file":
+		return readSecret("", ref)
+	case "exec":
+		return execSecret(ref)
+	}
+
+	return value, nil
+}
+
+// execSecret runs command, without a shell, and returns its trimmed output.
+func execSecret(command string) (string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return "", fmt.Errorf("no command was provided")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...) // nolint:gosec // command comes from the plugin config
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("command %s failed: %w", args[0], err)
+	}
+
+	secret := strings.TrimSpace(string(out))
+	if secret == "" {
+		return "", fmt.Errorf("command %s returned nothing", args[0])
+	}
+
+	return secret, nil
+}
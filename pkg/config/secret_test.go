@@ -0,0 +1,57 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSecret(t *testing.T) {
+	assert := require.New(t)
+	t.Setenv("ASERTO_TEST_SECRET", "from-env")
+	file := filepath.Join(t.TempDir(), "secret")
+	assert.NoError(os.WriteFile(file, []byte("from-file\n"), 0600))
+
+	secret, err := resolveSecret("plain-key")
+	assert.NoError(err)
+	assert.Equal("plain-key", secret)
+
+	secret, err = resolveSecret("env:ASERTO_TEST_SECRET")
+	assert.NoError(err)
+	assert.Equal("from-env", secret)
+
+	secret, err = resolveSecret("file:" + file)
+	assert.NoError(err)
+	assert.Equal("from-file", secret)
+}
+
+func TestResolveSecretExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("relies on echo and sh")
+	}
+	assert := require.New(t)
+
+	secret, err := resolveSecret("exec:echo from-exec")
+	assert.NoError(err)
+	assert.Equal("from-exec", secret)
+
+	script := filepath.Join(t.TempDir(), "secret.sh")
+	assert.NoError(os.WriteFile(script, []byte("#!/bin/sh\necho leaked\nexit 1\n"), 0700)) // nolint:gosec // test script
+	_, err = resolveSecret("exec:" + script)
+	assert.Error(err)
+	assert.NotContains(err.Error(), "leaked")
+}
+
+func TestResolveSecretMissing(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := resolveSecret("env:ASERTO_TEST_UNSET_SECRET")
+	assert.Error(err)
+	assert.Equal("environment variable ASERTO_TEST_UNSET_SECRET is not set", err.Error())
+
+	_, err = resolveSecret("file:" + filepath.Join(t.TempDir(), "missing"))
+	assert.Error(err)
+}
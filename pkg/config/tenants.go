@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	sdkconfig "github.com/aserto-dev/idp-plugin-sdk/config"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"gopkg.in/yaml.v3"
+)
+
+// tenantsFile lists tenants as plugin attributes, such as
+//
+//	tenants:
+//	  - tenant: 3dbaa470-9c4f-11ec-9a7e-0a4d3d4d7a9c
+//	    api-key: env:ACME_API_KEY
+//	    transform-file: acme.yaml
+//
+// Attributes that are not set are taken from the config listing the file.
+type tenantsFile struct {
+	Tenants []map[string]interface{} `yaml:"tenants"`
+}
+
+// TenantConfigs returns the config of each tenant listed in the tenants file.
+func (c *AsertoConfig) TenantConfigs() ([]*AsertoConfig, error) {
+	data, err := os.ReadFile(c.TenantsFile)
+	if err != nil {
+		return nil, fmt.Errorf("read tenants file: %w", err)
+	}
+
+	var file tenantsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse tenants file %s: %w", c.TenantsFile, err)
+	}
+
+	if len(file.Tenants) == 0 {
+		return nil, fmt.Errorf("no tenants found in %s", c.TenantsFile)
+	}
+
+	configs := make([]*AsertoConfig, 0, len(file.Tenants))
+	for i, attributes := range file.Tenants {
+		if _, ok := attributes["tenants-file"]; ok {
+			return nil, fmt.Errorf("tenant %d: tenants files cannot be nested", i+1)
+		}
+
+		values, err := structpb.NewStruct(attributes)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %d: %w", i+1, err)
+		}
+
+		conf := *c
+		conf.TenantsFile = ""
+		if err := sdkconfig.NewConfig(values, &conf); err != nil {
+			return nil, fmt.Errorf("tenant %d: %w", i+1, err)
+		}
+
+		configs = append(configs, &conf)
+	}
+
+	return configs, nil
+}
+
+// Name identifies the tenant of the config in messages.
+func (c *AsertoConfig) Name() string {
+	if c.File != "" {
+		return c.File
+	}
+
+	return c.Tenant
+}
+
+func (c *AsertoConfig) validateTenants(operation plugin.OperationType) error {
+	if operation == plugin.OperationTypeRead {
+		return status.Error(codes.InvalidArgument, "a tenants file can only be used to write or delete users")
+	}
+
+	configs, err := c.TenantConfigs()
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid tenants file: %s", err.Error())
+	}
+
+	for _, conf := range configs {
+		if err := conf.Validate(operation); err != nil {
+			return status.Errorf(status.Code(err), "tenant %s: %s", conf.Name(), status.Convert(err).Message())
+		}
+	}
+
+	return nil
+}
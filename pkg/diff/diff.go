@@ -0,0 +1,332 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type ChangeType string
+
+const (
+	Create ChangeType = "create"
+	Update ChangeType = "update"
+	Delete ChangeType = "delete"
+)
+
+// FieldChange describes how a single field of a user changes. List fields
+// report the values added and removed, other fields their old and new value.
+type FieldChange struct {
+	Field   string      `json:"field"`
+	Added   []string    `json:"added,omitempty"`
+	Removed []string    `json:"removed,omitempty"`
+	From    interface{} `json:"from,omitempty"`
+	To      interface{} `json:"to,omitempty"`
+}
+
+// MarshalJSON writes the values added and removed of list fields, and the old
+// and new value of other fields even when false, empty or absent.
+func (c FieldChange) MarshalJSON() ([]byte, error) {
+	if c.Added != nil || c.Removed != nil {
+		return json.Marshal(struct {
+			Field   string   `json:"field"`
+			Added   []string `json:"added,omitempty"`
+			Removed []string `json:"removed,omitempty"`
+		}{c.Field, c.Added, c.Removed})
+	}
+
+	return json.Marshal(struct {
+		Field string      `json:"field"`
+		From  interface{} `json:"from"`
+		To    interface{} `json:"to"`
+	}{c.Field, c.From, c.To})
+}
+
+type UserChange struct {
+	Type        ChangeType    `json:"type"`
+	ID          string        `json:"id"`
+	DisplayName string        `json:"display_name,omitempty"`
+	Fields      []FieldChange `json:"fields,omitempty"`
+}
+
+// Report lists the changes needed to turn the current set of users into the
+// incoming one.
+type Report struct {
+	Created   int          `json:"created"`
+	Updated   int          `json:"updated"`
+	Deleted   int          `json:"deleted"`
+	Unchanged int          `json:"unchanged"`
+	Changes   []UserChange `json:"changes"`
+}
+
+// Compare matches users by ID. Incoming users missing from current are
+// created, current users missing from incoming are deleted. Metadata is not
+// compared.
+func Compare(current, incoming []*api.User) *Report {
+	report := &Report{Changes: []UserChange{}}
+
+	currentByID := make(map[string]*api.User, len(current))
+	for _, user := range current {
+		currentByID[user.Id] = user
+	}
+
+	seen := make(map[string]bool, len(incoming))
+	for _, user := range incoming {
+		// Users without an ID are always created and match no current user.
+		if user.Id != "" {
+			seen[user.Id] = true
+		}
+
+		existing, ok := currentByID[user.Id]
+		if !ok || user.Id == "" {
+			report.Created++
+			report.Changes = append(report.Changes, UserChange{
+				Type:        Create,
+				ID:          user.Id,
+				DisplayName: user.DisplayName,
+				Fields:      compareUsers(&api.User{}, user),
+			})
+			continue
+		}
+
+		fields := compareUsers(existing, user)
+		if len(fields) == 0 {
+			report.Unchanged++
+			continue
+		}
+
+		report.Updated++
+		report.Changes = append(report.Changes, UserChange{
+			Type:        Update,
+			ID:          user.Id,
+			DisplayName: user.DisplayName,
+			Fields:      fields,
+		})
+	}
+
+	for _, user := range current {
+		if seen[user.Id] {
+			continue
+		}
+		report.Deleted++
+		report.Changes = append(report.Changes, UserChange{
+			Type:        Delete,
+			ID:          user.Id,
+			DisplayName: user.DisplayName,
+		})
+	}
+
+	return report
+}
+
+func compareUsers(from, to *api.User) []FieldChange {
+	var changes []FieldChange
+
+	changes = appendScalar(changes, "display_name", from.DisplayName, to.DisplayName)
+	changes = appendScalar(changes, "email", from.Email, to.Email)
+	changes = appendScalar(changes, "picture", from.Picture, to.Picture)
+	if from.Enabled != nil || to.Enabled != nil {
+		if from.GetEnabled() != to.GetEnabled() {
+			changes = append(changes, FieldChange{Field: "enabled", From: from.GetEnabled(), To: to.GetEnabled()})
+		}
+	}
+	if from.Deleted != to.Deleted {
+		changes = append(changes, FieldChange{Field: "deleted", From: from.Deleted, To: to.Deleted})
+	}
+
+	for _, key := range unionKeys(identityKeys(from.Identities), identityKeys(to.Identities)) {
+		a, b := from.Identities[key], to.Identities[key]
+		if proto.Equal(a, b) {
+			continue
+		}
+		changes = append(changes, FieldChange{
+			Field: "identities." + key,
+			From:  identityString(a),
+			To:    identityString(b),
+		})
+	}
+
+	changes = append(changes, compareAttrSets("attributes", from.Attributes, to.Attributes)...)
+
+	for _, app := range unionKeys(attrSetKeys(from.Applications), attrSetKeys(to.Applications)) {
+		changes = append(changes, compareAttrSets("applications."+app, from.Applications[app], to.Applications[app])...)
+	}
+
+	return changes
+}
+
+func compareAttrSets(prefix string, from, to *api.AttrSet) []FieldChange {
+	var changes []FieldChange
+
+	changes = appendList(changes, prefix+".roles", from.GetRoles(), to.GetRoles())
+	changes = appendList(changes, prefix+".permissions", from.GetPermissions(), to.GetPermissions())
+
+	fromProps := from.GetProperties().GetFields()
+	toProps := to.GetProperties().GetFields()
+	for _, key := range unionKeys(valueKeys(fromProps), valueKeys(toProps)) {
+		a, b := fromProps[key], toProps[key]
+		if proto.Equal(a, b) {
+			continue
+		}
+		changes = append(changes, FieldChange{
+			Field: prefix + ".properties." + key,
+			From:  valueInterface(a),
+			To:    valueInterface(b),
+		})
+	}
+
+	return changes
+}
+
+func appendScalar(changes []FieldChange, field, from, to string) []FieldChange {
+	if from == to {
+		return changes
+	}
+
+	return append(changes, FieldChange{Field: field, From: from, To: to})
+}
+
+func appendList(changes []FieldChange, field string, from, to []string) []FieldChange {
+	added := difference(to, from)
+	removed := difference(from, to)
+	if len(added) == 0 && len(removed) == 0 {
+		return changes
+	}
+
+	return append(changes, FieldChange{Field: field, Added: added, Removed: removed})
+}
+
+// difference returns the values of a missing from b, sorted.
+func difference(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, v := range b {
+		in[v] = true
+	}
+
+	var result []string
+	for _, v := range a {
+		if !in[v] {
+			result = append(result, v)
+		}
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+func unionKeys(a, b []string) []string {
+	keys := append(append([]string{}, a...), b...)
+	sort.Strings(keys)
+
+	result := keys[:0]
+	for i, key := range keys {
+		if i == 0 || key != keys[i-1] {
+			result = append(result, key)
+		}
+	}
+
+	return result
+}
+
+func identityKeys(m map[string]*api.IdentitySource) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func attrSetKeys(m map[string]*api.AttrSet) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func valueKeys(m map[string]*structpb.Value) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func identityString(identity *api.IdentitySource) interface{} {
+	if identity == nil {
+		return nil
+	}
+
+	return fmt.Sprintf("%s/%s verified=%t",
+		strings.ToLower(strings.TrimPrefix(identity.Kind.String(), "IDENTITY_KIND_")), identity.Provider, identity.Verified)
+}
+
+func valueInterface(v *structpb.Value) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	return v.AsInterface()
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteText writes the report in a line oriented, human readable form.
+func (r *Report) WriteText(w io.Writer) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%d to create, %d to update, %d to delete, %d unchanged\n",
+		r.Created, r.Updated, r.Deleted, r.Unchanged)
+
+	for _, change := range r.Changes {
+		sign := map[ChangeType]string{Create: "+", Update: "~", Delete: "-"}[change.Type]
+		fmt.Fprintf(&b, "\n%s %s %s", sign, change.Type, change.ID)
+		if change.DisplayName != "" {
+			fmt.Fprintf(&b, " (%s)", change.DisplayName)
+		}
+		b.WriteString("\n")
+
+		for _, field := range change.Fields {
+			switch {
+			case field.Added != nil || field.Removed != nil:
+				fmt.Fprintf(&b, "    %s:", field.Field)
+				for _, v := range field.Added {
+					fmt.Fprintf(&b, " +%s", v)
+				}
+				for _, v := range field.Removed {
+					fmt.Fprintf(&b, " -%s", v)
+				}
+				b.WriteString("\n")
+			default:
+				fmt.Fprintf(&b, "    %s: %s -> %s\n", field.Field, formatValue(field.From), formatValue(field.To))
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatValue(v interface{}) string {
+	if v == nil {
+		return "<none>"
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+
+	return string(data)
+}
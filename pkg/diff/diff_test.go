@@ -0,0 +1,130 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func createTestUser(id, displayName string, roles ...string) *api.User {
+	return &api.User{
+		Id:          id,
+		DisplayName: displayName,
+		Identities: map[string]*api.IdentitySource{
+			"auth0|" + id: {Kind: api.IdentityKind_IDENTITY_KIND_PID, Provider: "auth0", Verified: true},
+		},
+		Attributes: &api.AttrSet{
+			Roles:      roles,
+			Properties: &structpb.Struct{Fields: map[string]*structpb.Value{}},
+		},
+		Metadata: &api.Metadata{UpdatedAt: timestamppb.Now()},
+	}
+}
+
+func TestCompare(t *testing.T) {
+	assert := require.New(t)
+
+	unchanged := createTestUser("1", "Same", "user")
+	updated := createTestUser("2", "Before", "user", "admin")
+	updated.Attributes.Properties.Fields["dept"] = structpb.NewStringValue("sales")
+	deleted := createTestUser("3", "Gone")
+
+	incomingUnchanged := createTestUser("1", "Same", "user")
+	incomingUpdated := createTestUser("2", "After", "user", "auditor")
+	incomingUpdated.Attributes.Properties.Fields["dept"] = structpb.NewStringValue("marketing")
+	incomingUpdated.Applications = map[string]*api.AttrSet{"app": {Permissions: []string{"read"}}}
+	created := createTestUser("4", "New", "user")
+
+	report := Compare(
+		[]*api.User{unchanged, updated, deleted},
+		[]*api.User{incomingUnchanged, incomingUpdated, created},
+	)
+
+	assert.Equal(1, report.Created)
+	assert.Equal(1, report.Updated)
+	assert.Equal(1, report.Deleted)
+	assert.Equal(1, report.Unchanged)
+	assert.Len(report.Changes, 3)
+
+	update := report.Changes[0]
+	assert.Equal(Update, update.Type)
+	assert.Equal("2", update.ID)
+	assert.Equal([]FieldChange{
+		{Field: "display_name", From: "Before", To: "After"},
+		{Field: "attributes.roles", Added: []string{"auditor"}, Removed: []string{"admin"}},
+		{Field: "attributes.properties.dept", From: "sales", To: "marketing"},
+		{Field: "applications.app.permissions", Added: []string{"read"}},
+	}, update.Fields)
+
+	assert.Equal(Create, report.Changes[1].Type)
+	assert.Equal("4", report.Changes[1].ID)
+	assert.Equal(Delete, report.Changes[2].Type)
+	assert.Equal("3", report.Changes[2].ID)
+}
+
+func TestCompareEmptyIDs(t *testing.T) {
+	assert := require.New(t)
+
+	report := Compare(
+		[]*api.User{createTestUser("", "Current")},
+		[]*api.User{createTestUser("", "Incoming")},
+	)
+
+	assert.Equal(1, report.Created)
+	assert.Equal(1, report.Deleted)
+	assert.Equal(Create, report.Changes[0].Type)
+	assert.Equal(Delete, report.Changes[1].Type)
+	assert.Equal("Current", report.Changes[1].DisplayName)
+}
+
+func TestReportOutput(t *testing.T) {
+	assert := require.New(t)
+	report := Compare(
+		[]*api.User{createTestUser("1", "Before", "user")},
+		[]*api.User{createTestUser("1", "After", "user", "admin")},
+	)
+
+	var text bytes.Buffer
+	assert.Nil(report.WriteText(&text))
+	assert.Equal(`0 to create, 1 to update, 0 to delete, 0 unchanged
+
+~ update 1 (After)
+    display_name: "Before" -> "After"
+    attributes.roles: +admin
+`, text.String())
+
+	var data bytes.Buffer
+	assert.Nil(report.WriteJSON(&data))
+	decoded := &Report{}
+	assert.Nil(json.Unmarshal(data.Bytes(), decoded))
+	assert.Equal(1, decoded.Updated)
+	assert.Equal("attributes.roles", decoded.Changes[0].Fields[1].Field)
+	assert.NotContains(data.String(), `"from": null`)
+	assert.NotContains(data.String(), `"to": null`)
+}
+
+func TestReportOutputKeepsFalseValues(t *testing.T) {
+	assert := require.New(t)
+	disabled := createTestUser("1", "Same", "user")
+	off, on := false, true
+	disabled.Enabled = &off
+	enabled := createTestUser("1", "Same", "user")
+	enabled.Enabled = &on
+
+	var data bytes.Buffer
+	assert.Nil(Compare([]*api.User{disabled}, []*api.User{enabled}).WriteJSON(&data))
+
+	decoded := map[string]interface{}{}
+	assert.Nil(json.Unmarshal(data.Bytes(), &decoded))
+	change := decoded["changes"].([]interface{})[0].(map[string]interface{})
+	field := change["fields"].([]interface{})[0].(map[string]interface{})
+	assert.Equal("enabled", field["field"])
+	assert.Contains(field, "from")
+	assert.Equal(false, field["from"])
+	assert.Equal(true, field["to"])
+}
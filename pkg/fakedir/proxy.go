@@ -0,0 +1,100 @@
+package fakedir
+
+import (
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// Proxy is an HTTP CONNECT proxy for tests. Whatever host a tunnel is
+// requested for, it connects to the requested port on the loopback
+// interface, so that made-up host names reach local servers.
+type Proxy struct {
+	// URL of the proxy.
+	URL string
+
+	server   *httptest.Server
+	username string
+	password string
+
+	mu      sync.Mutex
+	targets []string
+}
+
+// NewProxy starts a proxy requiring basic authentication with username and
+// password, or none if username is empty.
+func NewProxy(username, password string) *Proxy {
+	p := &Proxy{username: username, password: password}
+	p.server = httptest.NewServer(http.HandlerFunc(p.serveHTTP))
+	p.URL = p.server.URL
+
+	return p
+}
+
+// Targets returns the addresses tunnels were requested for.
+func (p *Proxy) Targets() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string{}, p.targets...)
+}
+
+// Close stops the proxy.
+func (p *Proxy) Close() {
+	p.server.CloseClientConnections()
+	p.server.Close()
+}
+
+func (p *Proxy) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if p.username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(p.username + ":" + p.password))
+		if r.Header.Get("Proxy-Authorization") != "Basic "+credentials {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="fakedir"`)
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+	}
+
+	p.mu.Lock()
+	p.targets = append(p.targets, r.Host)
+	p.mu.Unlock()
+
+	_, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	upstream, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	conn, buf, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		upstream.Close()
+		conn.Close()
+		return
+	}
+
+	go func() {
+		_, _ = io.Copy(upstream, buf)
+		upstream.Close()
+	}()
+	_, _ = io.Copy(conn, upstream)
+	conn.Close()
+}
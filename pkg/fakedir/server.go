@@ -0,0 +1,379 @@
+package fakedir
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/filedir"
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	_ "google.golang.org/grpc/encoding/gzip" // accept compressed calls
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	// BufnetAddr is the address to dial when connecting through DialOption.
+	BufnetAddr = "bufnet"
+
+	bufSize = 1024 * 1024
+)
+
+// Server is an in-memory directory service for tests. It serves TLS with a
+// self-signed certificate, either over an in-process bufconn listener or on
+// a local TCP port.
+type Server struct {
+	dir.UnimplementedDirectoryServer
+
+	// Store holds the users of the directory.
+	Store *filedir.Store
+
+	// APIKey, when set, is accepted as basic authorization.
+	APIKey string
+
+	// Token, when set, is accepted as bearer authorization.
+	Token string
+
+	// TenantID, when set, must be sent as aserto-tenant-id with every call.
+	TenantID string
+
+	// Delay, when set, holds every unary call before serving it.
+	Delay time.Duration
+
+	// CloseDelay, when set, holds the response to LoadUsers once the client
+	// closes the stream.
+	CloseDelay time.Duration
+
+	// ReadOnly rejects loading users, like read-only credentials.
+	ReadOnly bool
+
+	// RequireClientCert makes the server only accept clients presenting a
+	// certificate issued by IssueClientCert.
+	RequireClientCert bool
+
+	server   *grpc.Server
+	bufconn  *bufconn.Listener
+	listener net.Listener
+	cert     tls.Certificate
+	caCert   []byte
+
+	gateway     *http.Server
+	gatewayConn *grpc.ClientConn
+
+	mu        sync.Mutex
+	userAgent string
+	metadata  metadata.MD
+}
+
+// New creates a server holding users.
+func New(users ...*api.User) (*Server, error) {
+	cert, caCert, err := selfSignedCert()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		Store:  filedir.NewStore(users...),
+		cert:   cert,
+		caCert: caCert,
+	}
+
+	s.server = grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(&tls.Config{GetConfigForClient: s.tlsConfig, MinVersion: tls.VersionTLS12})),
+		grpc.UnaryInterceptor(s.unary),
+		grpc.StreamInterceptor(s.stream),
+	)
+	dir.RegisterDirectoryServer(s.server, s)
+
+	return s, nil
+}
+
+// StartBufconn serves on an in-process listener reachable through DialOption.
+func (s *Server) StartBufconn() {
+	s.bufconn = bufconn.Listen(bufSize)
+	s.serve(s.bufconn)
+}
+
+// StartTCP serves on a random local port and returns its address.
+func (s *Server) StartTCP() (string, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+
+	s.serve(lis)
+	return lis.Addr().String(), nil
+}
+
+func (s *Server) serve(lis net.Listener) {
+	s.listener = lis
+	go func() {
+		_ = s.server.Serve(lis)
+	}()
+}
+
+// DialOption routes connections to the bufconn listener.
+func (s *Server) DialOption() grpc.DialOption {
+	return grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return s.bufconn.DialContext(ctx)
+	})
+}
+
+// CACert returns the PEM encoded certificate of the server, to be trusted by clients.
+func (s *Server) CACert() []byte {
+	return s.caCert
+}
+
+// IssueClientCert returns a PEM encoded certificate and key for name, signed
+// by the certificate of the server.
+func (s *Server) IssueClientCert(name string) ([]byte, []byte, error) {
+	return clientCert(s.cert, name)
+}
+
+func (s *Server) tlsConfig(*tls.ClientHelloInfo) (*tls.Config, error) {
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{s.cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if s.RequireClientCert {
+		conf.ClientCAs = x509.NewCertPool()
+		conf.ClientCAs.AddCert(s.cert.Leaf)
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return conf, nil
+}
+
+// StartGateway serves the REST surface of the directory over HTTPS on a
+// random local port, like the grpc-gateway of the authorizer, and returns its
+// URL. Requests are forwarded to the gRPC service through an in-process
+// listener, so they are authorized the same way.
+func (s *Server) StartGateway() (string, error) {
+	lis := bufconn.Listen(bufSize)
+	go func() {
+		_ = s.server.Serve(lis)
+	}()
+
+	certPEM, keyPEM, err := s.IssueClientCert("gateway")
+	if err != nil {
+		return "", err
+	}
+	clientCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return "", err
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(s.cert.Leaf)
+
+	s.gatewayConn, err = grpc.Dial(BufnetAddr,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+			RootCAs:      roots,
+			Certificates: []tls.Certificate{clientCert},
+			ServerName:   BufnetAddr,
+			MinVersion:   tls.VersionTLS12,
+		})),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	mux := runtime.NewServeMux(runtime.WithIncomingHeaderMatcher(gatewayHeader))
+	if err := dir.RegisterDirectoryHandlerClient(context.Background(), mux, dir.NewDirectoryClient(s.gatewayConn)); err != nil {
+		return "", err
+	}
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+
+	s.gateway = &http.Server{
+		Handler:           mux,
+		TLSConfig:         &tls.Config{Certificates: []tls.Certificate{s.cert}, GetConfigForClient: s.tlsConfig, MinVersion: tls.VersionTLS12},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		_ = s.gateway.ServeTLS(tcp, "", "")
+	}()
+
+	return "https://" + tcp.Addr().String(), nil
+}
+
+// gatewayHeader forwards the tenant header, which the gateway drops by default.
+func gatewayHeader(key string) (string, bool) {
+	if strings.EqualFold(key, "aserto-tenant-id") {
+		return "aserto-tenant-id", true
+	}
+
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// Stop closes all connections and listeners.
+func (s *Server) Stop() {
+	if s.gateway != nil {
+		_ = s.gateway.Close()
+		_ = s.gatewayConn.Close()
+	}
+	s.server.Stop()
+}
+
+func (s *Server) ListUsers(ctx context.Context, req *dir.ListUsersRequest) (*dir.ListUsersResponse, error) {
+	users, next, err := s.Store.List(req.GetPage().GetToken(), req.GetPage().GetSize())
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Base {
+		for _, user := range users {
+			user.Attributes = nil
+			user.Applications = nil
+		}
+	}
+
+	return &dir.ListUsersResponse{
+		Results: users,
+		Page: &api.PaginationResponse{
+			NextToken:  next,
+			ResultSize: int32(len(users)),
+		},
+	}, nil
+}
+
+func (s *Server) GetUser(ctx context.Context, req *dir.GetUserRequest) (*dir.GetUserResponse, error) {
+	user, ok := s.Store.Get(req.GetId())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "user %s not found", req.GetId())
+	}
+
+	return &dir.GetUserResponse{Result: user}, nil
+}
+
+func (s *Server) GetIdentity(ctx context.Context, req *dir.GetIdentityRequest) (*dir.GetIdentityResponse, error) {
+	id, ok := s.Store.FindIdentity(req.GetIdentity())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "identity %s not found", req.GetIdentity())
+	}
+
+	return &dir.GetIdentityResponse{Id: id}, nil
+}
+
+func (s *Server) LoadUsers(stream dir.Directory_LoadUsersServer) error {
+	if s.ReadOnly {
+		return status.Error(codes.PermissionDenied, "read-only credentials cannot load users")
+	}
+
+	stats := &dir.LoadUsersResponse{}
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			if s.CloseDelay > 0 {
+				select {
+				case <-time.After(s.CloseDelay):
+				case <-stream.Context().Done():
+					return status.FromContextError(stream.Context().Err()).Err()
+				}
+			}
+			return stream.SendAndClose(stats)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Errors are reported through the stats, like the directory service does.
+		_ = s.Store.Load(req, stats)
+	}
+}
+
+func (s *Server) unary(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	if s.Delay > 0 {
+		select {
+		case <-time.After(s.Delay):
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+
+	return handler(ctx, req)
+}
+
+func (s *Server) stream(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.authorize(ss.Context()); err != nil {
+		return err
+	}
+
+	return handler(srv, ss)
+}
+
+// UserAgent returns the user agent of the last call.
+func (s *Server) UserAgent() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.userAgent
+}
+
+// Metadata returns the metadata of the last call.
+func (s *Server) Metadata() metadata.MD {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.metadata.Copy()
+}
+
+func (s *Server) authorize(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	s.mu.Lock()
+	s.metadata = md.Copy()
+	s.userAgent = first(md, "user-agent")
+	if agent := first(md, runtime.MetadataPrefix+"user-agent"); agent != "" {
+		// Calls through the gateway carry the user agent of the HTTP client.
+		s.userAgent = agent
+	}
+	s.mu.Unlock()
+
+	if s.APIKey != "" || s.Token != "" {
+		auth := strings.SplitN(first(md, "authorization"), " ", 2)
+		valid := len(auth) == 2 &&
+			(s.APIKey != "" && strings.EqualFold(auth[0], "basic") && auth[1] == s.APIKey ||
+				s.Token != "" && strings.EqualFold(auth[0], "bearer") && auth[1] == s.Token)
+		if !valid {
+			return status.Error(codes.Unauthenticated, "invalid credentials")
+		}
+	}
+
+	if s.TenantID != "" && first(md, "aserto-tenant-id") != s.TenantID {
+		return status.Error(codes.PermissionDenied, "unknown tenant")
+	}
+
+	return nil
+}
+
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
@@ -0,0 +1,104 @@
+package fakedir
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func dial(t *testing.T, s *Server) dir.DirectoryClient {
+	t.Helper()
+
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(s.CACert()))
+
+	conn, err := grpc.Dial(BufnetAddr,
+		s.DialOption(),
+		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12})),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return dir.NewDirectoryClient(conn)
+}
+
+func testUser(id, email string) *api.User {
+	return &api.User{
+		Id:          id,
+		DisplayName: "User " + id,
+		Email:       email,
+		Identities: map[string]*api.IdentitySource{
+			email: {Kind: api.IdentityKind_IDENTITY_KIND_EMAIL, Provider: "local", Verified: true},
+		},
+		Attributes: &api.AttrSet{Roles: []string{"admin"}},
+	}
+}
+
+func TestListUsersBase(t *testing.T) {
+	assert := require.New(t)
+	s, err := New(testUser("1", "one@unit.com"), testUser("2", "two@unit.com"))
+	assert.NoError(err)
+	s.StartBufconn()
+	defer s.Stop()
+
+	client := dial(t, s)
+	resp, err := client.ListUsers(context.Background(), &dir.ListUsersRequest{Page: &api.PaginationRequest{Size: 1}, Base: true})
+	assert.NoError(err)
+	assert.Len(resp.Results, 1)
+	assert.Nil(resp.Results[0].Attributes)
+	assert.NotEmpty(resp.Page.NextToken)
+
+	user, ok := s.Store.Get("1")
+	assert.True(ok)
+	assert.NotNil(user.Attributes)
+}
+
+func TestGetIdentity(t *testing.T) {
+	assert := require.New(t)
+	s, err := New(testUser("1", "one@unit.com"))
+	assert.NoError(err)
+	s.StartBufconn()
+	defer s.Stop()
+
+	client := dial(t, s)
+	resp, err := client.GetIdentity(context.Background(), &dir.GetIdentityRequest{Identity: "one@unit.com"})
+	assert.NoError(err)
+	assert.Equal("1", resp.Id)
+
+	_, err = client.GetIdentity(context.Background(), &dir.GetIdentityRequest{Identity: "none@unit.com"})
+	assert.Equal(codes.NotFound, status.Code(err))
+}
+
+func TestAuthorize(t *testing.T) {
+	assert := require.New(t)
+	s, err := New()
+	assert.NoError(err)
+	s.APIKey = "key"
+	s.TenantID = "tenant"
+	s.StartBufconn()
+	defer s.Stop()
+
+	client := dial(t, s)
+	req := &dir.ListUsersRequest{Page: &api.PaginationRequest{Size: 1}}
+
+	_, err = client.ListUsers(context.Background(), req)
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "basic key")
+	_, err = client.ListUsers(ctx, req)
+	assert.Equal(codes.PermissionDenied, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "aserto-tenant-id", "tenant")
+	_, err = client.ListUsers(ctx, req)
+	assert.NoError(err)
+}
@@ -0,0 +1,243 @@
+package filedir
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Client is a directory client backed by a JSON Lines file holding one
+// protojson encoded api.User per line. Users are kept in memory and the file
+// is rewritten when a LoadUsers stream is closed.
+type Client struct {
+	// DirectoryClient answers every RPC not supported offline with codes.Unimplemented.
+	dir.DirectoryClient
+
+	path  string
+	store *Store
+}
+
+// Open loads the users of the file at path.
+func Open(path string) (*Client, error) {
+	users, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		DirectoryClient: dir.NewDirectoryClient(unsupportedConn{}),
+		path:            path,
+		store:           NewStore(users...),
+	}, nil
+}
+
+// Create returns a client for an empty file at path. The file is only
+// written when a LoadUsers stream is closed.
+func Create(path string) (*Client, error) {
+	if _, err := os.Stat(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		DirectoryClient: dir.NewDirectoryClient(unsupportedConn{}),
+		path:            path,
+		store:           NewStore(),
+	}, nil
+}
+
+// Store returns the users held by the client.
+func (c *Client) Store() *Store {
+	return c.store
+}
+
+func (c *Client) ListUsers(ctx context.Context, in *dir.ListUsersRequest, opts ...grpc.CallOption) (*dir.ListUsersResponse, error) {
+	users, next, err := c.store.List(in.GetPage().GetToken(), in.GetPage().GetSize())
+	if err != nil {
+		return nil, err
+	}
+
+	return &dir.ListUsersResponse{
+		Results: users,
+		Page: &api.PaginationResponse{
+			NextToken:  next,
+			ResultSize: int32(len(users)),
+		},
+	}, nil
+}
+
+func (c *Client) GetUser(ctx context.Context, in *dir.GetUserRequest, opts ...grpc.CallOption) (*dir.GetUserResponse, error) {
+	user, ok := c.store.Get(in.GetId())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "user %s not found", in.GetId())
+	}
+
+	return &dir.GetUserResponse{Result: user}, nil
+}
+
+func (c *Client) GetIdentity(ctx context.Context, in *dir.GetIdentityRequest, opts ...grpc.CallOption) (*dir.GetIdentityResponse, error) {
+	id, ok := c.store.FindIdentity(in.GetIdentity())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "identity %s not found", in.GetIdentity())
+	}
+
+	return &dir.GetIdentityResponse{Id: id}, nil
+}
+
+func (c *Client) LoadUsers(ctx context.Context, opts ...grpc.CallOption) (dir.Directory_LoadUsersClient, error) {
+	return &loadUsersStream{ctx: ctx, client: c, stats: &dir.LoadUsersResponse{}}, nil
+}
+
+// Save writes all users to the file, replacing it atomically.
+func (c *Client) Save() error {
+	return WriteFile(c.path, c.store.Users())
+}
+
+// ReadFile reads the users of a JSON Lines file.
+func ReadFile(path string) ([]*api.User, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Decode(f)
+}
+
+// Decode reads protojson encoded users, one per line, until EOF.
+func Decode(r io.Reader) ([]*api.User, error) {
+	var users []*api.User
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		if data = bytes.TrimSpace(data); len(data) > 0 {
+			user := &api.User{}
+			if uerr := protojson.Unmarshal(data, user); uerr != nil {
+				return nil, fmt.Errorf("line %d: %w", line, uerr)
+			}
+			users = append(users, user)
+		}
+
+		if err == io.EOF {
+			return users, nil
+		}
+	}
+}
+
+// WriteFile writes users to a JSON Lines file, replacing it atomically.
+func WriteFile(path string, users []*api.User) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if err := Encode(w, users); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Encode writes users as protojson, one per line.
+func Encode(w io.Writer, users []*api.User) error {
+	for _, user := range users {
+		data, err := protojson.Marshal(user)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadUsersStream applies load requests to the client store as they are sent
+// and saves the file when the stream is closed.
+type loadUsersStream struct {
+	ctx    context.Context
+	client *Client
+	stats  *dir.LoadUsersResponse
+	closed bool
+}
+
+func (s *loadUsersStream) Send(req *dir.LoadUsersRequest) error {
+	if s.closed {
+		return status.Error(codes.FailedPrecondition, "stream is closed")
+	}
+	if err := s.ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+
+	// Errors are reported through the stats, like the directory service does.
+	_ = s.client.store.Load(req, s.stats)
+	return nil
+}
+
+func (s *loadUsersStream) CloseAndRecv() (*dir.LoadUsersResponse, error) {
+	if s.closed {
+		return nil, status.Error(codes.FailedPrecondition, "stream is closed")
+	}
+	s.closed = true
+
+	if err := s.client.Save(); err != nil {
+		return nil, status.Errorf(codes.Internal, "save %s: %s", s.client.path, err.Error())
+	}
+
+	return s.stats, nil
+}
+
+func (s *loadUsersStream) Header() (metadata.MD, error) { return metadata.MD{}, nil }
+func (s *loadUsersStream) Trailer() metadata.MD         { return metadata.MD{} }
+func (s *loadUsersStream) CloseSend() error             { return nil }
+func (s *loadUsersStream) Context() context.Context     { return s.ctx }
+
+func (s *loadUsersStream) SendMsg(m interface{}) error {
+	req, ok := m.(*dir.LoadUsersRequest)
+	if !ok {
+		return status.Errorf(codes.InvalidArgument, "unexpected message type %T", m)
+	}
+	return s.Send(req)
+}
+
+func (s *loadUsersStream) RecvMsg(m interface{}) error {
+	return status.Error(codes.Unimplemented, "use CloseAndRecv to receive the load users response")
+}
+
+// unsupportedConn fails every call made through it, so that directory RPCs
+// without an offline implementation return codes.Unimplemented.
+type unsupportedConn struct{}
+
+func (unsupportedConn) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+	return status.Errorf(codes.Unimplemented, "%s is not supported in offline mode", method)
+}
+
+func (unsupportedConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return nil, status.Errorf(codes.Unimplemented, "%s is not supported in offline mode", method)
+}
@@ -0,0 +1,115 @@
+package filedir
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func createTestUser(id, pid string) *api.User {
+	return &api.User{
+		Id:          id,
+		DisplayName: "User " + id,
+		Identities: map[string]*api.IdentitySource{
+			pid: {Kind: api.IdentityKind_IDENTITY_KIND_PID, Provider: "auth0", Verified: true},
+		},
+		Attributes: &api.AttrSet{Roles: []string{"user"}},
+	}
+}
+
+func loadUser(user *api.User) *dir.LoadUsersRequest {
+	return &dir.LoadUsersRequest{Data: &dir.LoadUsersRequest_User{User: user}}
+}
+
+func TestWriteAndReadFile(t *testing.T) {
+	assert := require.New(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "users.jsonl")
+
+	client, err := Create(path)
+	assert.Nil(err)
+
+	stream, err := client.LoadUsers(ctx)
+	assert.Nil(err)
+	assert.Nil(stream.Send(loadUser(createTestUser("1", "auth0|1"))))
+	assert.Nil(stream.Send(loadUser(createTestUser("2", "auth0|2"))))
+	assert.Nil(stream.Send(loadUser(createTestUser("3", "auth0|3"))))
+	assert.Nil(stream.Send(&dir.LoadUsersRequest{Data: &dir.LoadUsersRequest_UserExt{UserExt: &api.UserExt{
+		Id:           "auth0|2",
+		Applications: map[string]*api.AttrSet{"app": {Roles: []string{"viewer"}}},
+	}}}))
+	assert.Nil(stream.Send(&dir.LoadUsersRequest{Data: &dir.LoadUsersRequest_UserExt{UserExt: &api.UserExt{Id: "auth0|9"}}}))
+
+	stats, err := stream.CloseAndRecv()
+	assert.Nil(err)
+	assert.Equal(int32(5), stats.Received)
+	assert.Equal(int32(3), stats.Created)
+	assert.Equal(int32(1), stats.Updated)
+	assert.Equal(int32(1), stats.Errors)
+
+	client, err = Open(path)
+	assert.Nil(err)
+
+	resp, err := client.ListUsers(ctx, &dir.ListUsersRequest{Page: &api.PaginationRequest{Size: 2}})
+	assert.Nil(err)
+	assert.Len(resp.Results, 2)
+	assert.Equal("2", resp.Page.NextToken)
+
+	resp, err = client.ListUsers(ctx, &dir.ListUsersRequest{Page: &api.PaginationRequest{Size: 2, Token: resp.Page.NextToken}})
+	assert.Nil(err)
+	assert.Len(resp.Results, 1)
+	assert.Equal("", resp.Page.NextToken)
+
+	user, err := client.GetUser(ctx, &dir.GetUserRequest{Id: "2"})
+	assert.Nil(err)
+	assert.Equal([]string{"viewer"}, user.Result.Applications["app"].Roles)
+
+	identity, err := client.GetIdentity(ctx, &dir.GetIdentityRequest{Identity: "auth0|3"})
+	assert.Nil(err)
+	assert.Equal("3", identity.Id)
+
+	_, err = client.GetUser(ctx, &dir.GetUserRequest{Id: "4"})
+	assert.Equal(codes.NotFound, status.Code(err))
+}
+
+func TestDeleteUsers(t *testing.T) {
+	assert := require.New(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "users.jsonl")
+	assert.Nil(WriteFile(path, []*api.User{createTestUser("1", "auth0|1"), createTestUser("2", "auth0|2")}))
+
+	client, err := Open(path)
+	assert.Nil(err)
+
+	deleted := createTestUser("1", "auth0|1")
+	deleted.Deleted = true
+	stream, err := client.LoadUsers(ctx)
+	assert.Nil(err)
+	assert.Nil(stream.Send(loadUser(deleted)))
+	stats, err := stream.CloseAndRecv()
+	assert.Nil(err)
+	assert.Equal(int32(1), stats.Deleted)
+
+	users, err := ReadFile(path)
+	assert.Nil(err)
+	assert.Len(users, 1)
+	assert.Equal("2", users[0].Id)
+
+	_, err = client.GetIdentity(ctx, &dir.GetIdentityRequest{Identity: "auth0|1"})
+	assert.Equal(codes.NotFound, status.Code(err))
+}
+
+func TestUnsupportedRPC(t *testing.T) {
+	assert := require.New(t)
+	client, err := Create(filepath.Join(t.TempDir(), "users.jsonl"))
+	assert.Nil(err)
+
+	_, err = client.ListResources(context.Background(), &dir.ListResourcesRequest{})
+	assert.Equal(codes.Unimplemented, status.Code(err))
+}
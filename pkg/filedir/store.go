@@ -0,0 +1,237 @@
+package filedir
+
+import (
+	"strconv"
+	"sync"
+
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Store is an in-memory, ordered set of users that follows the lookup and
+// load semantics of the directory service.
+type Store struct {
+	mu         sync.RWMutex
+	users      []*api.User
+	ids        map[string]int
+	identities map[string]int
+}
+
+// NewStore creates a store holding copies of users.
+func NewStore(users ...*api.User) *Store {
+	s := &Store{}
+	for _, user := range users {
+		s.users = append(s.users, proto.Clone(user).(*api.User))
+	}
+	s.reindex()
+
+	return s
+}
+
+// Users returns copies of all users in the store, in insertion order.
+func (s *Store) Users() []*api.User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]*api.User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, proto.Clone(user).(*api.User))
+	}
+
+	return users
+}
+
+// List returns a page of up to size users starting at the position encoded
+// in token, together with the token of the next page, which is empty on the
+// last page.
+func (s *Store) List(token string, size int32) ([]*api.User, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	start := 0
+	if token != "" {
+		var err error
+		start, err = strconv.Atoi(token)
+		if err != nil || start < 0 || start > len(s.users) {
+			return nil, "", status.Errorf(codes.InvalidArgument, "invalid page token %q", token)
+		}
+	}
+
+	end := len(s.users)
+	if size > 0 && start+int(size) < end {
+		end = start + int(size)
+	}
+
+	users := make([]*api.User, 0, end-start)
+	for _, user := range s.users[start:end] {
+		users = append(users, proto.Clone(user).(*api.User))
+	}
+
+	next := ""
+	if end < len(s.users) {
+		next = strconv.Itoa(end)
+	}
+
+	return users, next, nil
+}
+
+// Get returns a copy of the user with the given ID.
+func (s *Store) Get(id string) (*api.User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := s.indexOf(id)
+	if i < 0 {
+		return nil, false
+	}
+
+	return proto.Clone(s.users[i]).(*api.User), true
+}
+
+// FindIdentity returns the ID of the user owning the identity.
+func (s *Store) FindIdentity(identity string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := s.indexOfIdentity(identity)
+	if i < 0 {
+		return "", false
+	}
+
+	return s.users[i].Id, true
+}
+
+// Load applies a LoadUsers request to the store and updates stats accordingly.
+func (s *Store) Load(req *dir.LoadUsersRequest, stats *dir.LoadUsersResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats.Received++
+
+	err := s.load(req, stats)
+	if err != nil {
+		stats.Errors++
+	}
+
+	return err
+}
+
+func (s *Store) load(req *dir.LoadUsersRequest, stats *dir.LoadUsersResponse) error {
+	switch data := req.Data.(type) {
+	case *dir.LoadUsersRequest_User:
+		user := data.User
+		if user.GetId() == "" {
+			return status.Errorf(codes.InvalidArgument, "user %q has no id", user.GetDisplayName())
+		}
+
+		i := s.indexOf(user.Id)
+		switch {
+		case user.Deleted:
+			if i >= 0 {
+				s.remove(i)
+				stats.Deleted++
+			}
+		case i >= 0:
+			s.unindex(s.users[i])
+			s.users[i] = proto.Clone(user).(*api.User)
+			s.index(i)
+			stats.Updated++
+		default:
+			s.users = append(s.users, proto.Clone(user).(*api.User))
+			s.index(len(s.users) - 1)
+			stats.Created++
+		}
+
+	case *dir.LoadUsersRequest_UserExt:
+		i := s.indexOfIdentity(data.UserExt.GetId())
+		if i < 0 {
+			return status.Errorf(codes.NotFound, "user with identity %s not found", data.UserExt.GetId())
+		}
+
+		user := s.users[i]
+		if data.UserExt.Attributes != nil {
+			user.Attributes = proto.Clone(data.UserExt.Attributes).(*api.AttrSet)
+		}
+		if user.Applications == nil {
+			user.Applications = make(map[string]*api.AttrSet)
+		}
+		for app, attrs := range data.UserExt.Applications {
+			user.Applications[app] = proto.Clone(attrs).(*api.AttrSet)
+		}
+		stats.Updated++
+
+	case *dir.LoadUsersRequest_DeleteUser:
+		i := s.indexOf(data.DeleteUser.GetId())
+		if i < 0 {
+			i = s.indexOfIdentity(data.DeleteUser.GetId())
+		}
+		if i >= 0 {
+			s.remove(i)
+			stats.Deleted++
+		}
+
+	case *dir.LoadUsersRequest_DeleteConnection:
+		kept := s.users[:0]
+		for _, user := range s.users {
+			if user.GetMetadata().GetConnectionId() == data.DeleteConnection.GetConnectionId() {
+				stats.Deleted++
+				continue
+			}
+			kept = append(kept, user)
+		}
+		s.users = kept
+		s.reindex()
+
+	default:
+		return status.Error(codes.InvalidArgument, "empty load users request")
+	}
+
+	return nil
+}
+
+func (s *Store) indexOf(id string) int {
+	if i, ok := s.ids[id]; ok {
+		return i
+	}
+
+	return -1
+}
+
+func (s *Store) indexOfIdentity(identity string) int {
+	if i, ok := s.identities[identity]; ok {
+		return i
+	}
+
+	return -1
+}
+
+func (s *Store) index(i int) {
+	user := s.users[i]
+	s.ids[user.Id] = i
+	for identity := range user.Identities {
+		s.identities[identity] = i
+	}
+}
+
+func (s *Store) unindex(user *api.User) {
+	delete(s.ids, user.Id)
+	for identity := range user.Identities {
+		delete(s.identities, identity)
+	}
+}
+
+func (s *Store) reindex() {
+	s.ids = make(map[string]int, len(s.users))
+	s.identities = make(map[string]int, len(s.users))
+	for i := range s.users {
+		s.index(i)
+	}
+}
+
+func (s *Store) remove(i int) {
+	s.users = append(s.users[:i], s.users[i+1:]...)
+	s.reindex()
+}
@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+// Instrument returns a directory client recording the duration and errors of
+// the calls made through client, whatever its transport. It returns client
+// itself on a nil *Metrics.
+func (m *Metrics) Instrument(client dir.DirectoryClient) dir.DirectoryClient {
+	if m == nil {
+		return client
+	}
+
+	return &instrumentedClient{DirectoryClient: client, metrics: m}
+}
+
+// observe records a call to method started at start.
+func (m *Metrics) observe(method string, start time.Time, err error) {
+	m.RPCDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.Errors.WithLabelValues(method, status.Code(err).String()).Inc()
+	}
+}
+
+type instrumentedClient struct {
+	dir.DirectoryClient
+	metrics *Metrics
+}
+
+func (c *instrumentedClient) ListUsers(ctx context.Context, in *dir.ListUsersRequest, opts ...grpc.CallOption) (*dir.ListUsersResponse, error) {
+	start := time.Now()
+	resp, err := c.DirectoryClient.ListUsers(ctx, in, opts...)
+	c.metrics.observe("ListUsers", start, err)
+
+	return resp, err
+}
+
+func (c *instrumentedClient) GetUser(ctx context.Context, in *dir.GetUserRequest, opts ...grpc.CallOption) (*dir.GetUserResponse, error) {
+	start := time.Now()
+	resp, err := c.DirectoryClient.GetUser(ctx, in, opts...)
+	c.metrics.observe("GetUser", start, err)
+
+	return resp, err
+}
+
+func (c *instrumentedClient) GetIdentity(ctx context.Context, in *dir.GetIdentityRequest, opts ...grpc.CallOption) (*dir.GetIdentityResponse, error) {
+	start := time.Now()
+	resp, err := c.DirectoryClient.GetIdentity(ctx, in, opts...)
+	c.metrics.observe("GetIdentity", start, err)
+
+	return resp, err
+}
+
+func (c *instrumentedClient) LoadUsers(ctx context.Context, opts ...grpc.CallOption) (dir.Directory_LoadUsersClient, error) {
+	start := time.Now()
+	stream, err := c.DirectoryClient.LoadUsers(ctx, opts...)
+	if err != nil {
+		c.metrics.observe("LoadUsers", start, err)
+		return nil, err
+	}
+
+	return &instrumentedStream{Directory_LoadUsersClient: stream, metrics: c.metrics, start: start}, nil
+}
+
+// instrumentedStream times every message sent and the stream as a whole.
+type instrumentedStream struct {
+	dir.Directory_LoadUsersClient
+	metrics *Metrics
+	start   time.Time
+}
+
+func (s *instrumentedStream) Send(req *dir.LoadUsersRequest) error {
+	start := time.Now()
+	err := s.Directory_LoadUsersClient.Send(req)
+	s.metrics.SendDuration.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		s.metrics.Errors.WithLabelValues("LoadUsers.Send", status.Code(err).String()).Inc()
+		return err
+	}
+
+	switch req.Data.(type) {
+	case *dir.LoadUsersRequest_User:
+		s.metrics.UsersSent.Inc()
+	case *dir.LoadUsersRequest_UserExt:
+		s.metrics.ExtensionsSent.Inc()
+	}
+
+	return nil
+}
+
+func (s *instrumentedStream) CloseAndRecv() (*dir.LoadUsersResponse, error) {
+	resp, err := s.Directory_LoadUsersClient.CloseAndRecv()
+	s.metrics.observe("LoadUsers", s.start, err)
+
+	return resp, err
+}
+
+// StatsHandler returns a gRPC stats handler counting transparent retries and
+// reconnects, which only the gRPC client sees. The handler keeps counting on
+// a nil *Metrics.
+func (m *Metrics) StatsHandler() *ConnStats {
+	return &ConnStats{metrics: m}
+}
+
+// ConnStats counts the transparent retries and reconnects of a connection.
+type ConnStats struct {
+	metrics    *Metrics
+	conns      int64
+	retries    int64
+	reconnects int64
+}
+
+// Retries returns the number of transparent retries so far.
+func (h *ConnStats) Retries() int64 {
+	return atomic.LoadInt64(&h.retries)
+}
+
+// Reconnects returns the number of times the connection was reestablished.
+func (h *ConnStats) Reconnects() int64 {
+	return atomic.LoadInt64(&h.reconnects)
+}
+
+func (h *ConnStats) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (h *ConnStats) HandleRPC(_ context.Context, s stats.RPCStats) {
+	if begin, ok := s.(*stats.Begin); ok && begin.IsTransparentRetryAttempt {
+		atomic.AddInt64(&h.retries, 1)
+		if h.metrics != nil {
+			h.metrics.Retries.Inc()
+		}
+	}
+}
+
+func (h *ConnStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *ConnStats) HandleConn(_ context.Context, s stats.ConnStats) {
+	if _, ok := s.(*stats.ConnBegin); ok && atomic.AddInt64(&h.conns, 1) > 1 {
+		atomic.AddInt64(&h.reconnects, 1)
+		if h.metrics != nil {
+			h.metrics.Reconnects.Inc()
+		}
+	}
+}
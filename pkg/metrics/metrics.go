@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "aserto_idp_plugin"
+
+// Metrics counts the work of one plugin operation. All methods do nothing on
+// a nil *Metrics, so that callers need not check whether metrics are enabled.
+type Metrics struct {
+	// Registry holds the metrics below, and no others.
+	Registry *prometheus.Registry
+
+	PagesRead      prometheus.Counter
+	UsersRead      prometheus.Counter
+	UsersSent      prometheus.Counter
+	ExtensionsSent prometheus.Counter
+	SendDuration   prometheus.Histogram
+	RPCDuration    *prometheus.HistogramVec
+	Errors         *prometheus.CounterVec
+	Retries        prometheus.Counter
+	Reconnects     prometheus.Counter
+
+	server *http.Server
+}
+
+// New registers the metrics in a registry of their own.
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		PagesRead: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "pages_read_total",
+			Help:      "Pages of users read from the directory.",
+		}),
+		UsersRead: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "users_read_total",
+			Help:      "Users read from the directory.",
+		}),
+		UsersSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "users_sent_total",
+			Help:      "Users sent on load users streams, including deleted users.",
+		}),
+		ExtensionsSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "extensions_sent_total",
+			Help:      "User extensions sent on load users streams.",
+		}),
+		SendDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "send_duration_seconds",
+			Help:      "Time to send one message on a load users stream.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}),
+		RPCDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "rpc_duration_seconds",
+			Help:      "Duration of calls to the directory, from open to close for streams.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		Errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "errors_total",
+			Help:      "Failed calls to the directory by status code.",
+		}, []string{"method", "code"}),
+		Retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retries_total",
+			Help:      "Calls retried transparently by the gRPC client.",
+		}),
+		Reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reconnects_total",
+			Help:      "Connections to the authorizer opened again after the first one.",
+		}),
+	}
+
+	m.Registry.MustRegister(
+		m.PagesRead, m.UsersRead, m.UsersSent, m.ExtensionsSent, m.SendDuration,
+		m.RPCDuration, m.Errors, m.Retries, m.Reconnects,
+	)
+
+	return m
+}
+
+// PageRead counts a page of users read.
+func (m *Metrics) PageRead(users int) {
+	if m == nil {
+		return
+	}
+
+	m.PagesRead.Inc()
+	m.UsersRead.Add(float64(users))
+}
+
+// Start serves the metrics at /metrics on addr until Stop is called.
+func (m *Metrics) Start(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Addr: lis.Addr().String(), Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	m.server = server
+
+	go func() {
+		_ = server.Serve(lis)
+	}()
+
+	return nil
+}
+
+// Addr returns the address the metrics are served on, or "" if they are not.
+func (m *Metrics) Addr() string {
+	if m == nil || m.server == nil {
+		return ""
+	}
+
+	return m.server.Addr
+}
+
+// Stop stops serving the metrics.
+func (m *Metrics) Stop() {
+	if m == nil || m.server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = m.server.Shutdown(ctx)
+	m.server = nil
+}
+
+// WriteFile writes the metrics to path in the text format read by the
+// textfile collector of the node exporter. The file is replaced atomically.
+func (m *Metrics) WriteFile(path string) error {
+	if m == nil {
+		return nil
+	}
+
+	return prometheus.WriteToTextfile(path, m.Registry)
+}
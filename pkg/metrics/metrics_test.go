@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/mocks"
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	gomock "github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+// observations returns how many durations of method were recorded.
+func observations(t *testing.T, m *Metrics, method string) uint64 {
+	t.Helper()
+
+	families, err := m.Registry.Gather()
+	require.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() != namespace+"_rpc_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.Metric {
+			for _, label := range metric.Label {
+				if label.GetName() == "method" && label.GetValue() == method {
+					return metric.Histogram.GetSampleCount()
+				}
+			}
+		}
+	}
+
+	return 0
+}
+
+func TestInstrumentCalls(t *testing.T) {
+	assert := require.New(t)
+	ctrl := gomock.NewController(t)
+	client := mocks.NewMockDirectoryClient(ctrl)
+	m := New()
+
+	client.EXPECT().ListUsers(gomock.Any(), gomock.Any()).Return(&dir.ListUsersResponse{}, nil)
+	client.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(nil, status.Error(codes.NotFound, "not found"))
+
+	instrumented := m.Instrument(client)
+	_, err := instrumented.ListUsers(context.Background(), &dir.ListUsersRequest{})
+	assert.NoError(err)
+	_, err = instrumented.GetUser(context.Background(), &dir.GetUserRequest{Id: "1"})
+	assert.Equal(codes.NotFound, status.Code(err))
+
+	assert.Equal(uint64(1), observations(t, m, "ListUsers"))
+	assert.Equal(uint64(1), observations(t, m, "GetUser"))
+	assert.Equal(float64(1), testutil.ToFloat64(m.Errors.WithLabelValues("GetUser", "NotFound")))
+	assert.Equal(float64(0), testutil.ToFloat64(m.Errors.WithLabelValues("ListUsers", "NotFound")))
+}
+
+func TestInstrumentLoadUsers(t *testing.T) {
+	assert := require.New(t)
+	ctrl := gomock.NewController(t)
+	client := mocks.NewMockDirectoryClient(ctrl)
+	stream := mocks.NewMockDirectory_LoadUsersClient(ctrl)
+	m := New()
+
+	client.EXPECT().LoadUsers(gomock.Any()).Return(stream, nil)
+	stream.EXPECT().Send(gomock.Any()).Return(nil).Times(2)
+	stream.EXPECT().Send(gomock.Any()).Return(status.Error(codes.Unavailable, "gone"))
+	stream.EXPECT().CloseAndRecv().Return(&dir.LoadUsersResponse{Received: 1}, nil)
+
+	s, err := m.Instrument(client).LoadUsers(context.Background())
+	assert.NoError(err)
+	assert.NoError(s.Send(&dir.LoadUsersRequest{Data: &dir.LoadUsersRequest_User{User: &api.User{Id: "1"}}}))
+	assert.NoError(s.Send(&dir.LoadUsersRequest{Data: &dir.LoadUsersRequest_UserExt{UserExt: &api.UserExt{Id: "1"}}}))
+	assert.Error(s.Send(&dir.LoadUsersRequest{Data: &dir.LoadUsersRequest_User{User: &api.User{Id: "2"}}}))
+	_, err = s.CloseAndRecv()
+	assert.NoError(err)
+
+	assert.Equal(float64(1), testutil.ToFloat64(m.UsersSent))
+	assert.Equal(float64(1), testutil.ToFloat64(m.ExtensionsSent))
+	assert.Equal(float64(1), testutil.ToFloat64(m.Errors.WithLabelValues("LoadUsers.Send", "Unavailable")))
+	assert.Equal(uint64(1), observations(t, m, "LoadUsers"))
+}
+
+func TestNilMetrics(t *testing.T) {
+	assert := require.New(t)
+	var m *Metrics
+
+	client := mocks.NewMockDirectoryClient(gomock.NewController(t))
+	assert.Equal(dir.DirectoryClient(client), m.Instrument(client))
+	m.PageRead(10)
+	m.Stop()
+	assert.NoError(m.WriteFile(filepath.Join(t.TempDir(), "metrics.prom")))
+	assert.Equal("", m.Addr())
+}
+
+func TestStatsHandler(t *testing.T) {
+	assert := require.New(t)
+	m := New()
+	handler := m.StatsHandler()
+
+	handler.HandleConn(context.Background(), &stats.ConnBegin{Client: true})
+	assert.Equal(float64(0), testutil.ToFloat64(m.Reconnects))
+	handler.HandleConn(context.Background(), &stats.ConnEnd{Client: true})
+	handler.HandleConn(context.Background(), &stats.ConnBegin{Client: true})
+	assert.Equal(float64(1), testutil.ToFloat64(m.Reconnects))
+
+	handler.HandleRPC(context.Background(), &stats.Begin{Client: true})
+	handler.HandleRPC(context.Background(), &stats.Begin{Client: true, IsTransparentRetryAttempt: true})
+	assert.Equal(float64(1), testutil.ToFloat64(m.Retries))
+	assert.Equal(int64(1), handler.Retries())
+	assert.Equal(int64(1), handler.Reconnects())
+
+	var noMetrics *Metrics
+	handler = noMetrics.StatsHandler()
+	handler.HandleRPC(context.Background(), &stats.Begin{Client: true, IsTransparentRetryAttempt: true})
+	assert.Equal(int64(1), handler.Retries())
+}
+
+func TestServeAndWriteFile(t *testing.T) {
+	assert := require.New(t)
+	m := New()
+	m.PageRead(3)
+
+	assert.NoError(m.Start("127.0.0.1:0"))
+	resp, err := http.Get("http://" + m.Addr() + "/metrics")
+	assert.NoError(err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.NoError(err)
+	assert.Contains(string(body), "aserto_idp_plugin_pages_read_total 1")
+	assert.Contains(string(body), "aserto_idp_plugin_users_read_total 3")
+
+	addr := m.Addr()
+	m.Stop()
+	_, err = http.Get("http://" + addr + "/metrics")
+	assert.Error(err)
+
+	path := filepath.Join(t.TempDir(), "metrics.prom")
+	assert.NoError(m.WriteFile(path))
+	data, err := os.ReadFile(path)
+	assert.NoError(err)
+	assert.Contains(string(data), "aserto_idp_plugin_users_read_total 3")
+}
@@ -0,0 +1,236 @@
+package progress
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is a snapshot of the progress of an operation.
+type Event struct {
+	// Operation is the name of the operation, such as Export or Import.
+	Operation string
+
+	// Read is the number of users read from the directory.
+	Read int64
+
+	// Written is the number of users sent to the directory.
+	Written int64
+
+	// Errors is the number of users that could not be read or written.
+	Errors int64
+
+	// Total is the number of users the operation is expected to process,
+	// zero if unknown.
+	Total int64
+
+	// Elapsed is the time since the operation started.
+	Elapsed time.Duration
+
+	// Idle is the time since a user was last read or written.
+	Idle time.Duration
+
+	// Rate is the average number of users processed per second.
+	Rate float64
+
+	// Remaining is the estimated time left, zero if the total is unknown.
+	Remaining time.Duration
+
+	// Final is set on the last event of the operation.
+	Final bool
+}
+
+// Processed is the number of users read or written so far.
+func (e *Event) Processed() int64 {
+	return e.Read + e.Written
+}
+
+func (e *Event) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %d read, %d written, %d errors in %s, %.1f users/s",
+		strings.ToLower(e.Operation), e.Read, e.Written, e.Errors, e.Elapsed.Round(time.Second), e.Rate)
+
+	if e.Total > 0 {
+		fmt.Fprintf(&b, ", %d/%d (%d%%)", e.Processed(), e.Total, e.Processed()*100/e.Total)
+		if e.Remaining > 0 {
+			fmt.Fprintf(&b, ", about %s remaining", e.Remaining.Round(time.Second))
+		}
+	}
+
+	if e.Final {
+		b.WriteString(", done")
+	} else if e.Idle >= time.Minute {
+		fmt.Fprintf(&b, ", no progress for %s", e.Idle.Round(time.Second))
+	}
+
+	return b.String()
+}
+
+// Log logs e with the standard logger.
+func Log(e *Event) {
+	log.Printf("progress: %s", e)
+}
+
+// Reporter counts the users processed by an operation and reports its
+// progress periodically. All methods do nothing on a nil *Reporter, so that
+// callers need not check whether progress is reported.
+type Reporter struct {
+	operation string
+	report    func(*Event)
+	stop      chan struct{}
+	stopOnce  sync.Once
+	done      chan struct{}
+	now       func() time.Time
+
+	mu           sync.Mutex
+	start        time.Time
+	lastActivity time.Time
+	read         int64
+	written      int64
+	errors       int64
+	total        int64
+}
+
+// New returns a reporter calling report, or Log if nil, every interval until
+// stopped. A reporter with no interval only reports when stopped.
+func New(operation string, interval time.Duration, report func(*Event)) *Reporter {
+	if report == nil {
+		report = Log
+	}
+
+	r := &Reporter{
+		operation: operation,
+		report:    report,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		now:       time.Now,
+	}
+	r.start = r.now()
+	r.lastActivity = r.start
+
+	if interval > 0 {
+		go r.run(interval)
+	} else {
+		close(r.done)
+	}
+
+	return r
+}
+
+func (r *Reporter) run(interval time.Duration) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.report(r.Event())
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// SetTotal sets the number of users the operation is expected to process.
+func (r *Reporter) SetTotal(total int) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.total = int64(total)
+}
+
+// AddRead counts n users read.
+func (r *Reporter) AddRead(n int) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.read += int64(n)
+	r.lastActivity = r.now()
+}
+
+// AddWritten counts n users written.
+func (r *Reporter) AddWritten(n int) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.written += int64(n)
+	r.lastActivity = r.now()
+}
+
+// AddError counts a user that could not be read or written.
+func (r *Reporter) AddError() {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors++
+	r.lastActivity = r.now()
+}
+
+// Event returns the current progress, nil on a nil *Reporter.
+func (r *Reporter) Event() *Event {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	e := &Event{
+		Operation: r.operation,
+		Read:      r.read,
+		Written:   r.written,
+		Errors:    r.errors,
+		Total:     r.total,
+		Elapsed:   now.Sub(r.start),
+		Idle:      now.Sub(r.lastActivity),
+	}
+
+	if e.Elapsed > 0 {
+		e.Rate = float64(e.Processed()) / e.Elapsed.Seconds()
+	}
+	if left := e.Total - e.Processed(); left > 0 && e.Rate > 0 {
+		e.Remaining = time.Duration(float64(left) / e.Rate * float64(time.Second))
+	}
+
+	return e
+}
+
+// Stop stops the periodic reports.
+func (r *Reporter) Stop() {
+	if r == nil {
+		return
+	}
+
+	r.stopOnce.Do(func() { close(r.stop) })
+	<-r.done
+}
+
+// Finish stops the periodic reports and reports the final progress.
+func (r *Reporter) Finish() {
+	if r == nil {
+		return
+	}
+
+	r.Stop()
+
+	e := r.Event()
+	e.Final = true
+	r.report(e)
+}
@@ -0,0 +1,109 @@
+package progress
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// clock is a settable time source for reporters.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func newTestReporter(report func(*Event)) (*Reporter, *clock) {
+	c := &clock{now: time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)}
+	r := New("Import", 0, report)
+	r.now = c.Now
+	r.start = c.now
+	r.lastActivity = c.now
+
+	return r, c
+}
+
+func TestEvent(t *testing.T) {
+	assert := require.New(t)
+	r, c := newTestReporter(nil)
+	r.SetTotal(1000)
+
+	c.now = c.now.Add(10 * time.Second)
+	r.AddWritten(200)
+	r.AddError()
+
+	c.now = c.now.Add(10 * time.Second)
+	e := r.Event()
+	assert.Equal(int64(200), e.Written)
+	assert.Equal(int64(1), e.Errors)
+	assert.Equal(20*time.Second, e.Elapsed)
+	assert.Equal(10*time.Second, e.Idle)
+	assert.Equal(float64(10), e.Rate)
+	assert.Equal(80*time.Second, e.Remaining)
+	assert.Equal("import: 0 read, 200 written, 1 errors in 20s, 10.0 users/s, 200/1000 (20%), about 1m20s remaining", e.String())
+
+	c.now = c.now.Add(2 * time.Minute)
+	assert.Contains(r.Event().String(), ", no progress for 2m10s")
+}
+
+func TestEventWithoutTotal(t *testing.T) {
+	assert := require.New(t)
+	r, c := newTestReporter(nil)
+
+	c.now = c.now.Add(4 * time.Second)
+	r.AddRead(100)
+
+	e := r.Event()
+	assert.Equal(time.Duration(0), e.Remaining)
+	assert.Equal("import: 100 read, 0 written, 0 errors in 4s, 25.0 users/s", e.String())
+}
+
+func TestPeriodicReports(t *testing.T) {
+	assert := require.New(t)
+	events := make(chan *Event, 100)
+
+	r := New("Export", 10*time.Millisecond, func(e *Event) { events <- e })
+	r.AddRead(5)
+
+	e := <-events
+	assert.False(e.Final)
+	assert.Equal(int64(5), e.Read)
+
+	r.Finish()
+	for e = range events {
+		if e.Final {
+			break
+		}
+	}
+	assert.True(e.Final)
+	assert.Equal("Export", e.Operation)
+	assert.Contains(e.String(), ", done")
+
+	r.Stop()
+	assert.Len(events, 0)
+}
+
+func TestStopDoesNotReport(t *testing.T) {
+	reported := false
+	r := New("Delete", 0, func(e *Event) { reported = true })
+	r.Stop()
+	r.Stop()
+
+	require.False(t, reported)
+}
+
+func TestNilReporter(t *testing.T) {
+	assert := require.New(t)
+	var r *Reporter
+
+	r.SetTotal(10)
+	r.AddRead(1)
+	r.AddWritten(1)
+	r.AddError()
+	r.Stop()
+	r.Finish()
+	assert.Nil(r.Event())
+}
@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// Dialer opens connections through an HTTP proxy with the CONNECT method.
+// Without an explicit URL, the proxy is taken from the HTTPS_PROXY
+// environment variable, and NO_PROXY always selects hosts dialed directly.
+type Dialer struct {
+	// URL of the proxy, such as http://proxy.example.com:3128.
+	URL string
+
+	// Username and Password, when set, authenticate with the proxy. They
+	// take precedence over credentials in the URL.
+	Username string
+	Password string
+
+	UserAgent string
+}
+
+// ProxyURL returns the proxy used to reach addr, or nil if it is dialed
+// directly.
+func (d *Dialer) ProxyURL(addr string) (*url.URL, error) {
+	conf := httpproxy.FromEnvironment()
+	if d.URL != "" {
+		conf.HTTPSProxy = d.URL
+	}
+
+	proxyURL, err := conf.ProxyFunc()(&url.URL{Scheme: "https", Host: addr})
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url: %w", err)
+	}
+	if proxyURL == nil {
+		return nil, nil
+	}
+
+	if d.Username != "" {
+		proxyURL.User = url.UserPassword(d.Username, d.Password)
+	}
+
+	return proxyURL, nil
+}
+
+// Proxy selects the proxy of HTTP requests, for use as http.Transport.Proxy.
+func (d *Dialer) Proxy(req *http.Request) (*url.URL, error) {
+	addr := req.URL.Host
+	if req.URL.Port() == "" {
+		addr = net.JoinHostPort(req.URL.Hostname(), "443")
+	}
+
+	return d.ProxyURL(addr)
+}
+
+// DialContext connects to addr through the proxy, or directly if no proxy
+// applies to it.
+func (d *Dialer) DialContext(ctx context.Context, addr string) (net.Conn, error) {
+	proxyURL, err := d.ProxyURL(addr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+
+	return d.connect(ctx, proxyURL, addr)
+}
+
+// connect asks the proxy at proxyURL for a tunnel to addr.
+func (d *Dialer) connect(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("dial proxy %s: %w", proxyAddr, err)
+	}
+
+	if proxyURL.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname(), MinVersion: tls.VersionTLS12})
+	}
+
+	// Abort the handshake when the context ends before the proxy answers.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if d.UserAgent != "" {
+		req.Header.Set("User-Agent", d.UserAgent)
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, d.connectError(ctx, proxyAddr, err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, d.connectError(ctx, proxyAddr, err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusProxyAuthRequired:
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s requires authentication, set proxy-username and proxy-password", proxyAddr)
+	default:
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused to connect to %s: %s", proxyAddr, addr, resp.Status)
+	}
+
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+
+	return conn, nil
+}
+
+func (d *Dialer) connectError(ctx context.Context, proxyAddr string, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+	}
+
+	return fmt.Errorf("connect through proxy %s: %w", proxyAddr, err)
+}
+
+// bufferedConn reads what the proxy sent after its response before reading
+// from the connection.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,294 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	usersPath      = "/api/v1/dir/users"
+	identitiesPath = "/api/v1/dir/identities"
+	loadUsersPath  = "/aserto.authorizer.directory.v1.Directory/LoadUsers"
+
+	tenantHeader = "aserto-tenant-id"
+
+	// metadataHeaderPrefix marks headers the gateway passes on as gRPC metadata.
+	metadataHeaderPrefix = "Grpc-Metadata-"
+)
+
+// Config describes how to reach the REST surface of the authorizer.
+type Config struct {
+	// URL is the base URL of the authorizer gateway, such as https://authorizer.example.com.
+	URL string
+
+	// TenantID is sent as aserto-tenant-id with every request.
+	TenantID string
+
+	// Credentials, when set, provide the authorization header of every request.
+	Credentials credentials.PerRPCCredentials
+
+	// TLSConfig, when set, replaces the default TLS settings.
+	TLSConfig *tls.Config
+
+	// Proxy selects the proxy of each request, http.ProxyFromEnvironment if nil.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	UserAgent string
+}
+
+// Client is a directory client calling the grpc-gateway REST surface of the
+// authorizer over HTTPS. Errors are returned as gRPC status errors, so that
+// callers cannot tell it from a gRPC client.
+type Client struct {
+	// DirectoryClient answers every RPC not supported over REST with codes.Unimplemented.
+	dir.DirectoryClient
+
+	baseURL    *url.URL
+	httpClient *http.Client
+	conf       *Config
+}
+
+// New returns a client for the gateway at conf.URL.
+func New(conf *Config) (*Client, error) {
+	baseURL, err := url.Parse(conf.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+	if baseURL.Scheme != "https" && baseURL.Scheme != "http" {
+		return nil, fmt.Errorf("url %s must use https", conf.URL)
+	}
+
+	proxy := conf.Proxy
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	if conf.TLSConfig != nil {
+		transport.TLSClientConfig = conf.TLSConfig
+	}
+
+	return &Client{
+		DirectoryClient: dir.NewDirectoryClient(unsupportedConn{}),
+		baseURL:         baseURL,
+		httpClient:      &http.Client{Transport: transport},
+		conf:            conf,
+	}, nil
+}
+
+// Close releases the idle connections of the client.
+func (c *Client) Close() error {
+	c.httpClient.CloseIdleConnections()
+	return nil
+}
+
+func (c *Client) ListUsers(ctx context.Context, in *dir.ListUsersRequest, opts ...grpc.CallOption) (*dir.ListUsersResponse, error) {
+	query := url.Values{}
+	if size := in.GetPage().GetSize(); size != 0 {
+		query.Set("page.size", strconv.Itoa(int(size)))
+	}
+	if token := in.GetPage().GetToken(); token != "" {
+		query.Set("page.token", token)
+	}
+	if in.GetBase() {
+		query.Set("base", "true")
+	}
+
+	resp := &dir.ListUsersResponse{}
+	if err := c.call(ctx, http.MethodGet, usersPath, query, nil, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (c *Client) GetUser(ctx context.Context, in *dir.GetUserRequest, opts ...grpc.CallOption) (*dir.GetUserResponse, error) {
+	resp := &dir.GetUserResponse{}
+	if err := c.call(ctx, http.MethodGet, usersPath+"/"+url.PathEscape(in.GetId()), nil, nil, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (c *Client) GetIdentity(ctx context.Context, in *dir.GetIdentityRequest, opts ...grpc.CallOption) (*dir.GetIdentityResponse, error) {
+	body, err := protojson.Marshal(in)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "marshal request: %s", err.Error())
+	}
+
+	resp := &dir.GetIdentityResponse{}
+	if err := c.call(ctx, http.MethodPost, identitiesPath, nil, bytes.NewReader(body), resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// LoadUsers streams the requests sent on the returned stream as the body of a
+// single request, one JSON object per line, and reads the stats from its
+// response once the stream is closed.
+func (c *Client) LoadUsers(ctx context.Context, opts ...grpc.CallOption) (dir.Directory_LoadUsersClient, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+
+	body, writer := io.Pipe()
+	stream := &loadUsersStream{ctx: ctx, writer: writer, done: make(chan struct{})}
+
+	go func() {
+		defer close(stream.done)
+
+		resp := &dir.LoadUsersResponse{}
+		stream.err = c.call(ctx, http.MethodPost, loadUsersPath, nil, body, resp)
+		stream.resp = resp
+
+		// Unblock Send when the request ended before the body was read.
+		_ = body.CloseWithError(io.ErrClosedPipe)
+	}()
+
+	return stream, nil
+}
+
+// call sends a request with the tenant and authorization headers, and the
+// outgoing metadata of ctx, and decodes the response into out, or returns the
+// status of a failed request.
+func (c *Client) call(ctx context.Context, method, path string, query url.Values, body io.Reader, out proto.Message) error {
+	u := *c.baseURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return status.Errorf(codes.Internal, "create request: %s", err.Error())
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.conf.UserAgent != "" {
+		req.Header.Set("User-Agent", c.conf.UserAgent)
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		for key, values := range md {
+			for _, value := range values {
+				req.Header.Add(metadataHeaderPrefix+key, value)
+			}
+		}
+	}
+	if c.conf.TenantID != "" {
+		req.Header.Set(tenantHeader, c.conf.TenantID)
+	}
+	if c.conf.Credentials != nil {
+		md, err := c.conf.Credentials.GetRequestMetadata(ctx, u.String())
+		if err != nil {
+			return status.Errorf(codes.Unauthenticated, "get request credentials: %s", err.Error())
+		}
+		for key, value := range md {
+			req.Header.Set(key, value)
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return status.FromContextError(ctxErr).Err()
+		}
+		return status.Errorf(codes.Unavailable, "%s %s: %s", method, path, err.Error())
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "read response: %s", err.Error())
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp.StatusCode, data)
+	}
+
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, out); err != nil {
+		return status.Errorf(codes.Internal, "decode response: %s", err.Error())
+	}
+
+	return nil
+}
+
+// gatewayError is the body of a failed gateway response.
+type gatewayError struct {
+	Code    int32  `json:"code"`
+	Message string `json:"message"`
+}
+
+// responseError converts a failed response into a gRPC status error, using
+// the status of the body when the gateway sent one.
+func responseError(statusCode int, data []byte) error {
+	var gwErr gatewayError
+	if err := json.Unmarshal(data, &gwErr); err == nil && gwErr.Code != 0 {
+		return status.Error(codes.Code(gwErr.Code), gwErr.Message)
+	}
+
+	message := strings.TrimSpace(string(data))
+	if message == "" {
+		message = http.StatusText(statusCode)
+	}
+
+	return status.Error(httpCode(statusCode), message)
+}
+
+// httpCode maps HTTP status codes to gRPC codes, the reverse of what the
+// gateway does.
+func httpCode(statusCode int) codes.Code {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable, http.StatusBadGateway:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+
+	return codes.Unknown
+}
+
+// unsupportedConn fails every call made through it, so that directory RPCs
+// without a REST implementation return codes.Unimplemented.
+type unsupportedConn struct{}
+
+func (unsupportedConn) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+	return status.Errorf(codes.Unimplemented, "%s is not supported by the rest transport", method)
+}
+
+func (unsupportedConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return nil, status.Errorf(codes.Unimplemented, "%s is not supported by the rest transport", method)
+}
@@ -0,0 +1,212 @@
+package rest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"testing"
+
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/auth"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/fakedir"
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testAPIKey = "rest-api-key"
+	testTenant = "3dbaa470-9c4f-11ec-9a7e-0a4d3d4d7a9c"
+)
+
+func testUser(id, email string) *api.User {
+	return &api.User{
+		Id:          id,
+		DisplayName: "User " + id,
+		Email:       email,
+		Identities: map[string]*api.IdentitySource{
+			email: {Kind: api.IdentityKind_IDENTITY_KIND_EMAIL, Provider: "local", Verified: true},
+		},
+		Attributes: &api.AttrSet{Roles: []string{"admin"}},
+	}
+}
+
+func startGateway(t *testing.T, users ...*api.User) (*fakedir.Server, *Config) {
+	t.Helper()
+
+	server, err := fakedir.New(users...)
+	require.NoError(t, err)
+	server.APIKey = testAPIKey
+	server.TenantID = testTenant
+
+	url, err := server.StartGateway()
+	require.NoError(t, err)
+	t.Cleanup(server.Stop)
+
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(server.CACert()))
+
+	return server, &Config{
+		URL:         url,
+		TenantID:    testTenant,
+		Credentials: auth.APIKey(testAPIKey),
+		TLSConfig:   &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		UserAgent:   "rest-test",
+	}
+}
+
+func newClient(t *testing.T, conf *Config) *Client {
+	t.Helper()
+
+	client, err := New(conf)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+
+	return client
+}
+
+func TestListUsersPages(t *testing.T) {
+	assert := require.New(t)
+	server, conf := startGateway(t, testUser("1", "one@unit.com"), testUser("2", "two@unit.com"), testUser("3", "three@unit.com"))
+	client := newClient(t, conf)
+
+	var ids []string
+	token := ""
+	for {
+		resp, err := client.ListUsers(context.Background(), &dir.ListUsersRequest{Page: &api.PaginationRequest{Size: 2, Token: token}, Base: true})
+		assert.NoError(err)
+		for _, user := range resp.Results {
+			assert.Nil(user.Attributes)
+			ids = append(ids, user.Id)
+		}
+		if token = resp.Page.NextToken; token == "" {
+			break
+		}
+	}
+
+	assert.Equal([]string{"1", "2", "3"}, ids)
+	assert.Equal("rest-test", server.UserAgent())
+}
+
+func TestGetUserAndIdentity(t *testing.T) {
+	assert := require.New(t)
+	_, conf := startGateway(t, testUser("1", "one@unit.com"))
+	client := newClient(t, conf)
+
+	user, err := client.GetUser(context.Background(), &dir.GetUserRequest{Id: "1"})
+	assert.NoError(err)
+	assert.Equal("User 1", user.Result.DisplayName)
+	assert.Equal([]string{"admin"}, user.Result.Attributes.Roles)
+
+	_, err = client.GetUser(context.Background(), &dir.GetUserRequest{Id: "2"})
+	assert.Equal(codes.NotFound, status.Code(err))
+
+	identity, err := client.GetIdentity(context.Background(), &dir.GetIdentityRequest{Identity: "one@unit.com"})
+	assert.NoError(err)
+	assert.Equal("1", identity.Id)
+
+	_, err = client.GetIdentity(context.Background(), &dir.GetIdentityRequest{Identity: "none@unit.com"})
+	assert.Equal(codes.NotFound, status.Code(err))
+}
+
+func TestLoadUsers(t *testing.T) {
+	assert := require.New(t)
+	server, conf := startGateway(t, testUser("1", "one@unit.com"))
+	client := newClient(t, conf)
+
+	stream, err := client.LoadUsers(context.Background())
+	assert.NoError(err)
+
+	renamed := testUser("1", "one@unit.com")
+	renamed.DisplayName = "Renamed"
+	assert.NoError(stream.Send(&dir.LoadUsersRequest{Data: &dir.LoadUsersRequest_User{User: renamed}}))
+	assert.NoError(stream.Send(&dir.LoadUsersRequest{Data: &dir.LoadUsersRequest_User{User: testUser("2", "two@unit.com")}}))
+
+	stats, err := stream.CloseAndRecv()
+	assert.NoError(err)
+	assert.Equal(int32(2), stats.Received)
+	assert.Equal(int32(1), stats.Created)
+	assert.Equal(int32(1), stats.Updated)
+
+	user, ok := server.Store.Get("1")
+	assert.True(ok)
+	assert.Equal("Renamed", user.DisplayName)
+	_, ok = server.Store.Get("2")
+	assert.True(ok)
+
+	_, err = stream.CloseAndRecv()
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+}
+
+func TestLoadUsersReadOnly(t *testing.T) {
+	assert := require.New(t)
+	server, conf := startGateway(t)
+	server.ReadOnly = true
+	client := newClient(t, conf)
+
+	stream, err := client.LoadUsers(context.Background())
+	assert.NoError(err)
+
+	_, err = stream.CloseAndRecv()
+	assert.Equal(codes.PermissionDenied, status.Code(err))
+}
+
+func TestInvalidCredentials(t *testing.T) {
+	assert := require.New(t)
+	_, conf := startGateway(t)
+	conf.Credentials = auth.APIKey("wrong")
+	client := newClient(t, conf)
+
+	_, err := client.ListUsers(context.Background(), &dir.ListUsersRequest{})
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+
+	conf.Credentials = auth.APIKey(testAPIKey)
+	conf.TenantID = "other"
+	client = newClient(t, conf)
+	_, err = client.ListUsers(context.Background(), &dir.ListUsersRequest{})
+	assert.Equal(codes.PermissionDenied, status.Code(err))
+}
+
+func TestUntrustedCertificate(t *testing.T) {
+	assert := require.New(t)
+	_, conf := startGateway(t)
+	conf.TLSConfig = nil
+	client := newClient(t, conf)
+
+	_, err := client.ListUsers(context.Background(), &dir.ListUsersRequest{})
+	assert.Equal(codes.Unavailable, status.Code(err))
+	assert.Contains(err.Error(), "certificate")
+}
+
+func TestUnsupported(t *testing.T) {
+	assert := require.New(t)
+	_, conf := startGateway(t)
+	client := newClient(t, conf)
+
+	_, err := client.ListResources(context.Background(), &dir.ListResourcesRequest{})
+	assert.Equal(codes.Unimplemented, status.Code(err))
+	assert.Contains(err.Error(), "not supported by the rest transport")
+}
+
+func TestInvalidURL(t *testing.T) {
+	_, err := New(&Config{URL: "ftp://authorizer.unit.com"})
+	require.Error(t, err)
+}
+
+func TestResponseError(t *testing.T) {
+	assert := require.New(t)
+
+	err := responseError(http.StatusNotFound, []byte(`{"code":5,"message":"user 1 not found","details":[]}`))
+	assert.Equal(codes.NotFound, status.Code(err))
+	assert.Equal("user 1 not found", status.Convert(err).Message())
+
+	err = responseError(http.StatusForbidden, []byte("blocked by proxy"))
+	assert.Equal(codes.PermissionDenied, status.Code(err))
+	assert.Equal("blocked by proxy", status.Convert(err).Message())
+
+	err = responseError(http.StatusBadGateway, nil)
+	assert.Equal(codes.Unavailable, status.Code(err))
+	assert.Equal("Bad Gateway", status.Convert(err).Message())
+}
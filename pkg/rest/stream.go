@@ -0,0 +1,86 @@
+package rest
+
+import (
+	"context"
+	"io"
+
+	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// loadUsersStream writes every request sent to the body of a pending
+// LoadUsers request. The response is read once the body is closed.
+type loadUsersStream struct {
+	ctx    context.Context
+	writer *io.PipeWriter
+	closed bool
+
+	// done is closed when the request completed, setting resp and err.
+	done chan struct{}
+	resp *dir.LoadUsersResponse
+	err  error
+}
+
+func (s *loadUsersStream) Send(req *dir.LoadUsersRequest) error {
+	if s.closed {
+		return status.Error(codes.FailedPrecondition, "stream is closed")
+	}
+	if err := s.ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+
+	data, err := protojson.Marshal(req)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "marshal request: %s", err.Error())
+	}
+
+	if _, err := s.writer.Write(append(data, '\n')); err != nil {
+		// The request ended early, its error tells why.
+		<-s.done
+		if s.err != nil {
+			return s.err
+		}
+		return io.EOF
+	}
+
+	return nil
+}
+
+func (s *loadUsersStream) CloseAndRecv() (*dir.LoadUsersResponse, error) {
+	if s.closed {
+		return nil, status.Error(codes.FailedPrecondition, "stream is closed")
+	}
+	s.closed = true
+
+	_ = s.writer.Close()
+	<-s.done
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	return s.resp, nil
+}
+
+func (s *loadUsersStream) Header() (metadata.MD, error) { return metadata.MD{}, nil }
+func (s *loadUsersStream) Trailer() metadata.MD         { return metadata.MD{} }
+func (s *loadUsersStream) Context() context.Context     { return s.ctx }
+
+func (s *loadUsersStream) CloseSend() error {
+	return s.writer.Close()
+}
+
+func (s *loadUsersStream) SendMsg(m interface{}) error {
+	req, ok := m.(*dir.LoadUsersRequest)
+	if !ok {
+		return status.Errorf(codes.InvalidArgument, "unexpected message type %T", m)
+	}
+	return s.Send(req)
+}
+
+func (s *loadUsersStream) RecvMsg(m interface{}) error {
+	return status.Error(codes.Unimplemented, "use CloseAndRecv to receive the load users response")
+}
@@ -0,0 +1,610 @@
+package srv
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	aserto "github.com/aserto-dev/aserto-go/client"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/config"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/fakedir"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/progress"
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	e2eAPIKey = "e2e-api-key"
+	e2eTenant = "3dbaa470-9c4f-11ec-9a7e-0a4d3d4d7a9c"
+)
+
+func startFakeDirectory(t *testing.T, users ...*api.User) *fakedir.Server {
+	t.Helper()
+
+	server, err := fakedir.New(users...)
+	require.NoError(t, err)
+	server.APIKey = e2eAPIKey
+	server.TenantID = e2eTenant
+	server.StartBufconn()
+	t.Cleanup(server.Stop)
+
+	return server
+}
+
+func newE2EPlugin(t *testing.T, server *fakedir.Server) (*AsertoPlugin, *config.AsertoConfig) {
+	t.Helper()
+
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caCert, server.CACert(), 0600))
+
+	p := NewAsertoPlugin()
+	p.connOptions = []aserto.ConnectionOption{
+		aserto.WithDialOptions(server.DialOption()),
+	}
+
+	return p, &config.AsertoConfig{
+		Authorizer: fakedir.BufnetAddr,
+		Tenant:     e2eTenant,
+		APIKey:     e2eAPIKey,
+		CACertPath: caCert,
+	}
+}
+
+func TestE2EReadPages(t *testing.T) {
+	assert := require.New(t)
+
+	var users []*api.User
+	for i := 0; i < int(pageSize)+10; i++ {
+		id := strconv.Itoa(i)
+		users = append(users, CreateTestAPIUser(id, "auth0|"+id, "User "+id, "user"+id+"@unit.com", "", "connectionId"))
+	}
+
+	p, cfg := newE2EPlugin(t, startFakeDirectory(t, users...))
+	assert.Nil(p.Open(cfg, plugin.OperationTypeRead))
+
+	var read []*api.User
+	pages := 0
+	for {
+		page, err := p.Read()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(err)
+		read = append(read, page...)
+		pages++
+	}
+
+	assert.Equal(2, pages)
+	assert.Len(read, len(users))
+	assert.Equal("User 0", read[0].DisplayName)
+
+	stats, err := p.Close()
+	assert.Nil(err)
+	assert.Nil(stats)
+	assert.Nil(p.conn)
+}
+
+func TestE2EWrite(t *testing.T) {
+	assert := require.New(t)
+	server := startFakeDirectory(t, CreateTestAPIUser("1", "auth0|1", "First Last", "test@unit.com", "0998976834", "connectionId"))
+
+	p, cfg := newE2EPlugin(t, server)
+	assert.Nil(p.Open(cfg, plugin.OperationTypeWrite))
+	assert.Nil(p.Write(CreateTestAPIUser("1", "auth0|1", "First Renamed", "test@unit.com", "0998976834", "connectionId")))
+	assert.Nil(p.Write(CreateTestAPIUser("2", "auth0|2", "Second Last", "test2@unit.com", "0998976835", "connectionId")))
+
+	stats, err := p.Close()
+	assert.Nil(err)
+	assert.Equal(int32(2), stats.Received)
+	assert.Equal(int32(1), stats.Created)
+	assert.Equal(int32(1), stats.Updated)
+	assert.Equal(int32(2), p.sendCount)
+
+	user, ok := server.Store.Get("1")
+	assert.True(ok)
+	assert.Equal("First Renamed", user.DisplayName)
+	_, ok = server.Store.Get("2")
+	assert.True(ok)
+}
+
+func TestE2EDelete(t *testing.T) {
+	assert := require.New(t)
+	id := "bd397e35-6333-11ec-b5cf-02a489f227f9"
+	server := startFakeDirectory(t,
+		CreateTestAPIUser(id, "auth0|1", "First Last", "test@unit.com", "0998976834", "connectionId"),
+		CreateTestAPIUser("2", "auth0|2", "Second Last", "test2@unit.com", "0998976835", "other"),
+	)
+
+	p, cfg := newE2EPlugin(t, server)
+	assert.Nil(p.Open(cfg, plugin.OperationTypeDelete))
+	assert.Nil(p.Delete(id))
+
+	stats, err := p.Close()
+	assert.Nil(err)
+	assert.Equal(int32(1), stats.Deleted)
+	assert.Len(server.Store.Users(), 1)
+}
+
+func TestE2EDeleteLookupIsNotRead(t *testing.T) {
+	assert := require.New(t)
+	server := startFakeDirectory(t,
+		CreateTestAPIUser("1", "auth0|1", "First Last", "test@unit.com", "0998976834", "connectionId"),
+		CreateTestAPIUser("2", "auth0|2", "Second Last", "test2@unit.com", "0998976835", "other"),
+	)
+
+	var events []*progress.Event
+	p, cfg := newE2EPlugin(t, server)
+	p.OnProgress = func(e *progress.Event) { events = append(events, e) }
+	cfg.MetricsFile = filepath.Join(t.TempDir(), "metrics.prom")
+	assert.Nil(p.Open(cfg, plugin.OperationTypeDelete))
+	assert.Nil(p.Delete(`#(email=="test2@unit.com")`))
+
+	_, err := p.Close()
+	assert.Nil(err)
+	assert.Equal(0, p.summary.PagesRead)
+	assert.Equal(0, p.summary.UsersRead)
+	assert.Equal(1, p.summary.UsersSent)
+	assert.Len(events, 1)
+	assert.Equal(int64(0), events[0].Read)
+	assert.Equal(int64(1), events[0].Written)
+
+	data, err := os.ReadFile(cfg.MetricsFile)
+	assert.Nil(err)
+	assert.Contains(string(data), "aserto_idp_plugin_pages_read_total 0")
+}
+
+func TestE2EDeleteMissingUser(t *testing.T) {
+	assert := require.New(t)
+
+	p, cfg := newE2EPlugin(t, startFakeDirectory(t))
+	assert.Nil(p.Open(cfg, plugin.OperationTypeDelete))
+
+	err := p.Delete("bd397e35-6333-11ec-b5cf-02a489f227f9")
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "code = NotFound")
+}
+
+func TestE2EInvalidAPIKey(t *testing.T) {
+	assert := require.New(t)
+
+	p, cfg := newE2EPlugin(t, startFakeDirectory(t))
+	cfg.APIKey = "wrong"
+	assert.Nil(p.Open(cfg, plugin.OperationTypeRead))
+
+	_, err := p.Read()
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "invalid credentials")
+}
+
+func TestE2EOpenWithMissingCACert(t *testing.T) {
+	assert := require.New(t)
+
+	p, cfg := newE2EPlugin(t, startFakeDirectory(t))
+	cfg.CACertPath = filepath.Join(t.TempDir(), "missing.pem")
+
+	err := p.Open(cfg, plugin.OperationTypeRead)
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+	assert.Contains(err.Error(), "invalid connection options")
+
+	_, err = p.Close()
+	assert.NoError(err)
+}
+
+func TestE2ETimeout(t *testing.T) {
+	assert := require.New(t)
+	server := startFakeDirectory(t, CreateTestAPIUser("1", "auth0|1", "First Last", "test@unit.com", "0998976834", "connectionId"))
+
+	p, cfg := newE2EPlugin(t, server)
+	cfg.Timeout = 1
+	assert.Nil(p.Open(cfg, plugin.OperationTypeRead))
+
+	server.Delay = 3 * time.Second
+	start := time.Now()
+	_, err := p.Read()
+	assert.Equal(codes.DeadlineExceeded, status.Code(err))
+	assert.Less(time.Since(start), 2*time.Second)
+}
+
+func TestE2ECloseTimeout(t *testing.T) {
+	assert := require.New(t)
+	server := startFakeDirectory(t)
+
+	p, cfg := newE2EPlugin(t, server)
+	cfg.Timeout = 1
+	assert.Nil(p.Open(cfg, plugin.OperationTypeWrite))
+	assert.Nil(p.Write(CreateTestAPIUser("1", "auth0|1", "First Last", "test@unit.com", "0998976834", "connectionId")))
+
+	server.CloseDelay = 3 * time.Second
+	start := time.Now()
+	_, err := p.Close()
+	assert.Equal(codes.DeadlineExceeded, status.Code(err))
+	assert.Contains(err.Error(), "stream close: no response after 1s")
+	assert.Less(time.Since(start), 2*time.Second)
+}
+
+func TestE2ECloseCanceled(t *testing.T) {
+	assert := require.New(t)
+	server := startFakeDirectory(t)
+
+	p, cfg := newE2EPlugin(t, server)
+	assert.Nil(p.Open(cfg, plugin.OperationTypeWrite))
+	assert.Nil(p.Write(CreateTestAPIUser("1", "auth0|1", "First Last", "test@unit.com", "0998976834", "connectionId")))
+
+	p.cancel()
+	err := p.Write(CreateTestAPIUser("2", "auth0|2", "Second Last", "test2@unit.com", "0998976835", "connectionId"))
+	assert.NotNil(err)
+
+	_, err = p.Close()
+	assert.Equal(codes.Canceled, status.Code(err))
+	assert.Empty(server.Store.Users())
+}
+
+func TestE2EClientCert(t *testing.T) {
+	assert := require.New(t)
+	server := startFakeDirectory(t, CreateTestAPIUser("1", "auth0|1", "First Last", "test@unit.com", "0998976834", "connectionId"))
+	server.RequireClientCert = true
+
+	certPEM, keyPEM, err := server.IssueClientCert("plugin")
+	assert.NoError(err)
+	dir := t.TempDir()
+	assert.NoError(os.WriteFile(filepath.Join(dir, "client.pem"), certPEM, 0600))
+	assert.NoError(os.WriteFile(filepath.Join(dir, "client.key"), keyPEM, 0600))
+
+	p, cfg := newE2EPlugin(t, server)
+	err = connectFailFast(cfg, p)
+	assert.NotNil(err)
+
+	cfg.ClientCertPath = filepath.Join(dir, "client.pem")
+	cfg.ClientKeyPath = filepath.Join(dir, "client.key")
+	assert.Nil(p.Open(cfg, plugin.OperationTypeRead))
+	users, err := p.Read()
+	assert.Nil(err)
+	assert.Len(users, 1)
+}
+
+func TestE2EServerNameOverride(t *testing.T) {
+	assert := require.New(t)
+	server := startFakeDirectory(t)
+
+	p, cfg := newE2EPlugin(t, server)
+	cfg.ServerNameOverride = "localhost"
+	assert.Nil(p.Open(cfg, plugin.OperationTypeRead))
+	_, err := p.Read()
+	assert.Nil(err)
+
+	cfg.ServerNameOverride = "authorizer.example.com"
+	err = connectFailFast(cfg, p)
+	assert.NotNil(err)
+}
+
+// connectFailFast connects without waiting for the default dial timeout, for
+// connections that are expected to fail.
+func connectFailFast(cfg *config.AsertoConfig, p *AsertoPlugin) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	conn, err := cfg.Connect(ctx, p.connOptions...)
+	if err == nil {
+		conn.Close()
+	}
+	return err
+}
+
+func TestE2ETokenFile(t *testing.T) {
+	assert := require.New(t)
+	server := startFakeDirectory(t, CreateTestAPIUser("1", "auth0|1", "First Last", "test@unit.com", "0998976834", "connectionId"))
+	server.Token = "static-token"
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(os.WriteFile(tokenFile, []byte("static-token\n"), 0600))
+
+	p, cfg := newE2EPlugin(t, server)
+	cfg.APIKey = ""
+	cfg.AuthMethod = config.AuthMethodToken
+	cfg.TokenFile = tokenFile
+	assert.Nil(p.Open(cfg, plugin.OperationTypeRead))
+
+	users, err := p.Read()
+	assert.Nil(err)
+	assert.Len(users, 1)
+}
+
+func TestE2EOAuth2(t *testing.T) {
+	assert := require.New(t)
+	server := startFakeDirectory(t, CreateTestAPIUser("1", "auth0|1", "First Last", "test@unit.com", "0998976834", "connectionId"))
+	server.Token = "oauth2-token"
+
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "client" || secret != "s3cret" {
+			http.Error(w, "invalid client", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"oauth2-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer tokens.Close()
+
+	t.Setenv("E2E_OAUTH2_SECRET", "s3cret")
+
+	p, cfg := newE2EPlugin(t, server)
+	cfg.APIKey = ""
+	cfg.AuthMethod = config.AuthMethodOAuth2
+	cfg.OAuth2TokenURL = tokens.URL
+	cfg.OAuth2ClientID = "client"
+	cfg.OAuth2ClientSecretEnv = "E2E_OAUTH2_SECRET"
+	assert.Nil(p.Open(cfg, plugin.OperationTypeRead))
+
+	users, err := p.Read()
+	assert.Nil(err)
+	assert.Len(users, 1)
+}
+
+func TestE2EAPIKeyReference(t *testing.T) {
+	assert := require.New(t)
+	t.Setenv("E2E_API_KEY", e2eAPIKey)
+
+	p, cfg := newE2EPlugin(t, startFakeDirectory(t))
+	cfg.APIKey = "env:E2E_API_KEY"
+	assert.Nil(p.Open(cfg, plugin.OperationTypeRead))
+
+	_, err := p.Read()
+	assert.Nil(err)
+}
+
+func TestE2EGzipAndKeepalive(t *testing.T) {
+	assert := require.New(t)
+	server := startFakeDirectory(t)
+
+	p, cfg := newE2EPlugin(t, server)
+	cfg.Gzip = true
+	cfg.KeepaliveTime = 30
+	cfg.KeepaliveTimeout = 10
+	assert.Nil(p.Open(cfg, plugin.OperationTypeWrite))
+	assert.Nil(p.Write(CreateTestAPIUser("1", "auth0|1", "First Last", "test@unit.com", "0998976834", "connectionId")))
+	stats, err := p.Close()
+	assert.Nil(err)
+	assert.Equal(int32(1), stats.Created)
+
+	p, _ = newE2EPlugin(t, server)
+	assert.Nil(p.Open(cfg, plugin.OperationTypeRead))
+	users, err := p.Read()
+	assert.Nil(err)
+	assert.Len(users, 1)
+}
+
+func TestE2EMaxReceiveMessageSize(t *testing.T) {
+	assert := require.New(t)
+	server := startFakeDirectory(t, CreateTestAPIUser("1", "auth0|1", "First Last", "test@unit.com", "0998976834", "connectionId"))
+
+	p, cfg := newE2EPlugin(t, server)
+	cfg.MaxReceiveMessageSize = 64
+	assert.Nil(p.Open(cfg, plugin.OperationTypeRead))
+
+	_, err := p.Read()
+	assert.Equal(codes.ResourceExhausted, status.Code(err))
+}
+
+func newRESTPlugin(t *testing.T, server *fakedir.Server) (*AsertoPlugin, *config.AsertoConfig) {
+	t.Helper()
+
+	url, err := server.StartGateway()
+	require.NoError(t, err)
+
+	p, cfg := newE2EPlugin(t, server)
+	cfg.Authorizer = url
+	cfg.Transport = config.TransportREST
+
+	return p, cfg
+}
+
+func TestE2ERESTTransport(t *testing.T) {
+	assert := require.New(t)
+	id := "bd397e35-6333-11ec-b5cf-02a489f227f9"
+	server := startFakeDirectory(t, CreateTestAPIUser(id, "auth0|1", "First Last", "test@unit.com", "0998976834", "connectionId"))
+
+	p, cfg := newRESTPlugin(t, server)
+	assert.Nil(cfg.Validate(plugin.OperationTypeWrite))
+	assert.Nil(p.Open(cfg, plugin.OperationTypeWrite))
+	assert.Nil(p.Write(CreateTestAPIUser("2", "auth0|2", "Second Last", "test2@unit.com", "0998976835", "connectionId")))
+	stats, err := p.Close()
+	assert.Nil(err)
+	assert.Equal(int32(1), stats.Created)
+	assert.Nil(p.conn)
+
+	p = NewAsertoPlugin()
+	assert.Nil(p.Open(cfg, plugin.OperationTypeRead))
+	users, err := p.Read()
+	assert.Nil(err)
+	assert.Len(users, 2)
+	_, err = p.Close()
+	assert.Nil(err)
+
+	p = NewAsertoPlugin()
+	assert.Nil(p.Open(cfg, plugin.OperationTypeDelete))
+	assert.Nil(p.Delete(id))
+	stats, err = p.Close()
+	assert.Nil(err)
+	assert.Equal(int32(1), stats.Deleted)
+	assert.Len(server.Store.Users(), 1)
+}
+
+func TestE2ERESTInvalidAPIKey(t *testing.T) {
+	assert := require.New(t)
+
+	p, cfg := newRESTPlugin(t, startFakeDirectory(t))
+	cfg.APIKey = "wrong"
+	assert.Nil(p.Open(cfg, plugin.OperationTypeRead))
+
+	_, err := p.Read()
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+	assert.Contains(err.Error(), "invalid credentials")
+}
+
+func TestE2EMetrics(t *testing.T) {
+	assert := require.New(t)
+	server := startFakeDirectory(t, CreateTestAPIUser("1", "auth0|1", "First Last", "test@unit.com", "0998976834", "connectionId"))
+
+	p, cfg := newE2EPlugin(t, server)
+	cfg.MetricsAddr = "127.0.0.1:0"
+	cfg.MetricsFile = filepath.Join(t.TempDir(), "metrics.prom")
+	cfg.SplitExtensions = true
+	assert.Nil(p.Open(cfg, plugin.OperationTypeWrite))
+	assert.Nil(p.Write(CreateTestAPIUser("2", "auth0|2", "Second Last", "test2@unit.com", "0998976835", "connectionId")))
+
+	resp, err := http.Get("http://" + p.metrics.Addr() + "/metrics")
+	assert.Nil(err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Nil(err)
+	assert.Contains(string(body), "aserto_idp_plugin_users_sent_total 1")
+	assert.Contains(string(body), "aserto_idp_plugin_extensions_sent_total 1")
+
+	_, err = p.Close()
+	assert.Nil(err)
+	assert.Nil(p.metrics)
+
+	data, err := os.ReadFile(cfg.MetricsFile)
+	assert.Nil(err)
+	assert.Contains(string(data), "aserto_idp_plugin_users_sent_total 1")
+	assert.Contains(string(data), `aserto_idp_plugin_rpc_duration_seconds_count{method="LoadUsers"} 1`)
+
+	p, _ = newE2EPlugin(t, server)
+	cfg.MetricsAddr = ""
+	assert.Nil(p.Open(cfg, plugin.OperationTypeRead))
+	_, err = p.Read()
+	assert.Nil(err)
+	_, err = p.Close()
+	assert.Nil(err)
+
+	data, err = os.ReadFile(cfg.MetricsFile)
+	assert.Nil(err)
+	assert.Contains(string(data), "aserto_idp_plugin_pages_read_total 1")
+	assert.Contains(string(data), "aserto_idp_plugin_users_read_total 2")
+}
+
+func TestE2ETracing(t *testing.T) {
+	assert := require.New(t)
+	server := startFakeDirectory(t, CreateTestAPIUser("1", "auth0|1", "First Last", "test@unit.com", "0998976834", "connectionId"))
+
+	p, cfg := newE2EPlugin(t, server)
+	cfg.TracingExporter = "file"
+	cfg.TracingFile = filepath.Join(t.TempDir(), "spans.json")
+	assert.Nil(p.Open(cfg, plugin.OperationTypeRead))
+
+	users, err := p.Read()
+	assert.Nil(err)
+	assert.Len(users, 1)
+	assert.Contains(server.Metadata().Get("traceparent")[0], p.span.SpanContext().TraceID().String())
+
+	_, err = p.Close()
+	assert.Nil(err)
+	assert.Nil(p.tracing)
+
+	data, err := os.ReadFile(cfg.TracingFile)
+	assert.Nil(err)
+	assert.Contains(string(data), `"Name":"Export"`)
+	assert.Contains(string(data), `"Name":"Read page"`)
+	assert.Contains(string(data), `"Name":"aserto.authorizer.directory.v1.Directory/ListUsers"`)
+}
+
+func TestE2EProgress(t *testing.T) {
+	assert := require.New(t)
+	var users []*api.User
+	for i := 0; i < int(pageSize)+10; i++ {
+		id := strconv.Itoa(i)
+		users = append(users, CreateTestAPIUser(id, "auth0|"+id, "User "+id, "user"+id+"@unit.com", "", "connectionId"))
+	}
+	server := startFakeDirectory(t, users...)
+
+	var events []*progress.Event
+	p, cfg := newE2EPlugin(t, server)
+	p.OnProgress = func(e *progress.Event) { events = append(events, e) }
+	cfg.ProgressCount = true
+	assert.Nil(p.Open(cfg, plugin.OperationTypeRead))
+	assert.Equal(int64(len(users)), p.progress.Event().Total)
+
+	for {
+		_, err := p.Read()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(err)
+	}
+	_, err := p.Close()
+	assert.Nil(err)
+
+	assert.Len(events, 1)
+	assert.True(events[0].Final)
+	assert.Equal(int64(len(users)), events[0].Read)
+	assert.Equal(int64(len(users)), events[0].Total)
+
+	events = nil
+	p, cfg = newE2EPlugin(t, server)
+	p.OnProgress = func(e *progress.Event) { events = append(events, e) }
+	cfg.ProgressTotal = 2
+	cfg.GenerateIDs = true
+	assert.Nil(p.Open(cfg, plugin.OperationTypeWrite))
+	assert.Nil(p.Write(CreateTestAPIUser("", "auth0|new", "New User", "new@unit.com", "", "connectionId")))
+	assert.NotNil(p.Write(&api.User{DisplayName: "No PID"}))
+	_, err = p.Close()
+	assert.Nil(err)
+
+	assert.Len(events, 1)
+	assert.Equal(int64(1), events[0].Written)
+	assert.Equal(int64(1), events[0].Errors)
+	assert.Equal(int64(2), events[0].Total)
+}
+
+func TestE2ESummaryReport(t *testing.T) {
+	assert := require.New(t)
+	server := startFakeDirectory(t, CreateTestAPIUser("1", "auth0|1", "First Last", "test@unit.com", "0998976834", "connectionId"))
+
+	p, cfg := newE2EPlugin(t, server)
+	cfg.ReportFile = filepath.Join(t.TempDir(), "report.json")
+	cfg.SplitExtensions = true
+	assert.Nil(p.Open(cfg, plugin.OperationTypeWrite))
+	assert.Nil(p.Write(CreateTestAPIUser("2", "auth0|2", "Second Last", "test2@unit.com", "0998976835", "connectionId")))
+	assert.Nil(p.Write(CreateTestAPIUser("3", "auth0|3", "Third Last", "test3@unit.com", "0998976836", "connectionId")))
+	_, err := p.Close()
+	assert.Nil(err)
+
+	var summary Summary
+	data, err := os.ReadFile(cfg.ReportFile)
+	assert.Nil(err)
+	assert.Nil(json.Unmarshal(data, &summary))
+	assert.Equal("Import", summary.Operation)
+	assert.Equal(e2eTenant, summary.Tenant)
+	assert.Equal(2, summary.UsersSent)
+	assert.Equal(2, summary.ExtensionsSent)
+	assert.Equal(int32(4), summary.Server.Received)
+	assert.Equal(int32(2), summary.Server.Created)
+	assert.Empty(summary.Mismatch)
+	assert.Greater(summary.DurationSeconds, float64(0))
+
+	p, _ = newE2EPlugin(t, server)
+	assert.Nil(p.Open(cfg, plugin.OperationTypeRead))
+	_, err = p.Read()
+	assert.Nil(err)
+	stats, err := p.Close()
+	assert.Nil(err)
+	assert.Nil(stats)
+
+	summary = Summary{}
+	data, err = os.ReadFile(cfg.ReportFile)
+	assert.Nil(err)
+	assert.Nil(json.Unmarshal(data, &summary))
+	assert.Equal("Export", summary.Operation)
+	assert.Equal(1, summary.PagesRead)
+	assert.Equal(3, summary.UsersRead)
+	assert.Nil(summary.Server)
+}
@@ -0,0 +1,150 @@
+package srv
+
+import (
+	"sort"
+
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/config"
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// findExistingUser looks up the verified email and phone identities of the
+// user in the directory and returns the first other user that owns one of them.
+func (s *AsertoPlugin) findExistingUser(user *api.User) (*api.User, error) {
+	keys := make([]string, 0, len(user.Identities))
+	for key := range user.Identities {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		identity := user.Identities[key]
+		if !identity.Verified {
+			continue
+		}
+		if identity.Kind != api.IdentityKind_IDENTITY_KIND_EMAIL && identity.Kind != api.IdentityKind_IDENTITY_KIND_PHONE {
+			continue
+		}
+
+		ctx, cancel := s.rpcContext()
+		resp, err := s.dirClient.GetIdentity(ctx, &dir.GetIdentityRequest{Identity: key})
+		cancel()
+		if status.Code(err) == codes.NotFound {
+			continue
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "get identity: %s", err.Error())
+		}
+
+		if resp.Id == "" || resp.Id == user.Id {
+			continue
+		}
+
+		ctx, cancel = s.rpcContext()
+		userResp, err := s.dirClient.GetUser(ctx, &dir.GetUserRequest{Id: resp.Id})
+		cancel()
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "get user: %s", err.Error())
+		}
+
+		if existing := userResp.GetResult(); existing != nil {
+			return existing, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// mergeUsers folds the incoming user into a copy of the existing one. Roles,
+// permissions and identities are combined; conflicting values are taken from
+// the side named by precedence, which defaults to the incoming user.
+func mergeUsers(existing, incoming *api.User, precedence string) *api.User {
+	preferIncoming := precedence != config.MergePrecedenceExisting
+	merged := proto.Clone(existing).(*api.User)
+
+	merged.DisplayName = mergeString(merged.DisplayName, incoming.DisplayName, preferIncoming)
+	merged.Email = mergeString(merged.Email, incoming.Email, preferIncoming)
+	merged.Picture = mergeString(merged.Picture, incoming.Picture, preferIncoming)
+
+	if incoming.Enabled != nil && (preferIncoming || merged.Enabled == nil) {
+		enabled := incoming.GetEnabled()
+		merged.Enabled = &enabled
+	}
+
+	if merged.Identities == nil {
+		merged.Identities = make(map[string]*api.IdentitySource)
+	}
+	for key, identity := range incoming.Identities {
+		if _, ok := merged.Identities[key]; !ok || preferIncoming {
+			merged.Identities[key] = proto.Clone(identity).(*api.IdentitySource)
+		}
+	}
+
+	merged.Attributes = mergeAttrSets(merged.Attributes, incoming.Attributes, preferIncoming)
+
+	if merged.Applications == nil {
+		merged.Applications = make(map[string]*api.AttrSet)
+	}
+	for app, attrs := range incoming.Applications {
+		merged.Applications[app] = mergeAttrSets(merged.Applications[app], attrs, preferIncoming)
+	}
+
+	return merged
+}
+
+func mergeString(existing, incoming string, preferIncoming bool) string {
+	if incoming == "" || (existing != "" && !preferIncoming) {
+		return existing
+	}
+	return incoming
+}
+
+func mergeAttrSets(existing, incoming *api.AttrSet, preferIncoming bool) *api.AttrSet {
+	if incoming == nil {
+		return existing
+	}
+	if existing == nil {
+		return proto.Clone(incoming).(*api.AttrSet)
+	}
+
+	existing.Roles = mergeStrings(existing.Roles, incoming.Roles)
+	existing.Permissions = mergeStrings(existing.Permissions, incoming.Permissions)
+
+	if incoming.Properties == nil {
+		return existing
+	}
+	if existing.Properties == nil {
+		existing.Properties = &structpb.Struct{}
+	}
+	if existing.Properties.Fields == nil {
+		existing.Properties.Fields = make(map[string]*structpb.Value)
+	}
+	for key, value := range incoming.Properties.Fields {
+		if _, ok := existing.Properties.Fields[key]; !ok || preferIncoming {
+			existing.Properties.Fields[key] = proto.Clone(value).(*structpb.Value)
+		}
+	}
+
+	return existing
+}
+
+// mergeStrings appends the values of b missing from a, keeping the order of both.
+func mergeStrings(a, b []string) []string {
+	seen := make(map[string]bool, len(a))
+	for _, v := range a {
+		seen[v] = true
+	}
+
+	for _, v := range b {
+		if !seen[v] {
+			a = append(a, v)
+			seen[v] = true
+		}
+	}
+
+	return a
+}
@@ -0,0 +1,108 @@
+package srv
+
+import (
+	"testing"
+
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/config"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/mocks"
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	directory "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	gomock "github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func addEmailIdentity(user *api.User, email string) {
+	user.Identities[email] = &api.IdentitySource{
+		Kind:     api.IdentityKind_IDENTITY_KIND_EMAIL,
+		Provider: "okta",
+		Verified: true,
+	}
+}
+
+func TestMergeUsersIncomingPrecedence(t *testing.T) {
+	assert := require.New(t)
+	existing := CreateTestAPIUser("1", "auth0|1", "Existing Name", "test@unit.com", "0998976834", "connectionId")
+	existing.Attributes.Properties.Fields["dept"] = structpb.NewStringValue("sales")
+	incoming := CreateTestAPIUser("2", "okta|2", "Incoming Name", "test@unit.com", "0998976834", "connectionId")
+	incoming.Attributes.Roles = []string{"User", "Admin"}
+	incoming.Attributes.Properties.Fields["dept"] = structpb.NewStringValue("marketing")
+	incoming.Applications["app"] = &api.AttrSet{Roles: []string{"viewer"}}
+
+	merged := mergeUsers(existing, incoming, config.MergePrecedenceIncoming)
+
+	assert.Equal("1", merged.Id)
+	assert.Equal("Incoming Name", merged.DisplayName)
+	assert.Equal([]string{"User", "Admin"}, merged.Attributes.Roles)
+	assert.Equal("marketing", merged.Attributes.Properties.Fields["dept"].GetStringValue())
+	assert.Contains(merged.Identities, "auth0|1")
+	assert.Contains(merged.Identities, "okta|2")
+	assert.Equal([]string{"viewer"}, merged.Applications["app"].Roles)
+	assert.Equal("Existing Name", existing.DisplayName, "existing user should not be modified")
+}
+
+func TestMergeUsersExistingPrecedence(t *testing.T) {
+	assert := require.New(t)
+	existing := CreateTestAPIUser("1", "auth0|1", "Existing Name", "test@unit.com", "0998976834", "connectionId")
+	existing.Attributes.Properties.Fields["dept"] = structpb.NewStringValue("sales")
+	incoming := CreateTestAPIUser("2", "okta|2", "Incoming Name", "", "0998976834", "connectionId")
+	incoming.Attributes.Properties.Fields["dept"] = structpb.NewStringValue("marketing")
+	incoming.Attributes.Properties.Fields["title"] = structpb.NewStringValue("manager")
+
+	merged := mergeUsers(existing, incoming, config.MergePrecedenceExisting)
+
+	assert.Equal("Existing Name", merged.DisplayName)
+	assert.Equal("test@unit.com", merged.Email)
+	assert.Equal("sales", merged.Attributes.Properties.Fields["dept"].GetStringValue())
+	assert.Equal("manager", merged.Attributes.Properties.Fields["title"].GetStringValue())
+}
+
+func TestWriteMergeIdentities(t *testing.T) {
+	assert := require.New(t)
+	p := NewTestAsertoPlugin(gomock.NewController(t), plugin.OperationTypeWrite)
+	p.mergeIdentities = true
+	existing := CreateTestAPIUser("1", "auth0|1", "First Last", "test@unit.com", "0998976834", "connectionId")
+	incoming := CreateTestAPIUser("2", "okta|2", "First Last", "test@unit.com", "0998976834", "connectionId")
+	addEmailIdentity(incoming, "test@unit.com")
+
+	p.dirClient.(*mocks.MockDirectoryClient).EXPECT().GetIdentity(p.ctx, &directory.GetIdentityRequest{Identity: "0998976834"}).Return(
+		nil, status.Error(codes.NotFound, "not found"))
+	p.dirClient.(*mocks.MockDirectoryClient).EXPECT().GetIdentity(p.ctx, &directory.GetIdentityRequest{Identity: "test@unit.com"}).Return(
+		&directory.GetIdentityResponse{Id: "1"}, nil)
+	p.dirClient.(*mocks.MockDirectoryClient).EXPECT().GetUser(p.ctx, gomock.Any()).Return(
+		&directory.GetUserResponse{Result: existing}, nil)
+
+	var sent *directory.LoadUsersRequest
+	p.loadUsersStream.(*mocks.MockDirectory_LoadUsersClient).EXPECT().Send(gomock.Any()).DoAndReturn(
+		func(req *directory.LoadUsersRequest) error {
+			sent = req
+			return nil
+		})
+
+	err := p.Write(incoming)
+
+	assert.Nil(err)
+	assert.Equal("1", sent.GetUser().Id)
+	assert.Contains(sent.GetUser().Identities, "auth0|1")
+	assert.Contains(sent.GetUser().Identities, "okta|2")
+	assert.Contains(sent.GetUser().Identities, "test@unit.com")
+}
+
+func TestWriteMergeIdentitiesLookupFail(t *testing.T) {
+	assert := require.New(t)
+	p := NewTestAsertoPlugin(gomock.NewController(t), plugin.OperationTypeWrite)
+	p.mergeIdentities = true
+	incoming := CreateTestAPIUser("2", "okta|2", "First Last", "test@unit.com", "0998976834", "connectionId")
+
+	p.dirClient.(*mocks.MockDirectoryClient).EXPECT().GetIdentity(p.ctx, gomock.Any()).Return(
+		nil, status.Error(codes.Unavailable, "#boom#"))
+
+	err := p.Write(incoming)
+
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = Internal desc = get identity: rpc error: code = Unavailable desc = #boom#", err.Error())
+	assert.Equal(int32(0), p.sendCount)
+}
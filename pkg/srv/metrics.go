@@ -0,0 +1,62 @@
+package srv
+
+import (
+	"log"
+
+	aserto "github.com/aserto-dev/aserto-go/client"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/config"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// openMetrics enables metrics when the config serves or writes them. The
+// plugins of a tenants file share the metrics of the plugin opening them.
+func (s *AsertoPlugin) openMetrics(conf *config.AsertoConfig) error {
+	if s.metrics != nil || (conf.MetricsAddr == "" && conf.MetricsFile == "") {
+		return nil
+	}
+
+	s.metrics = metrics.New()
+	s.ownsMetrics = true
+
+	if conf.MetricsAddr != "" {
+		if err := s.metrics.Start(conf.MetricsAddr); err != nil {
+			s.metrics, s.ownsMetrics = nil, false
+			return status.Errorf(codes.InvalidArgument, "serve metrics: %s", err.Error())
+		}
+		log.Printf("serving metrics on http://%s/metrics", s.metrics.Addr())
+	}
+
+	return nil
+}
+
+// closeMetrics writes the metrics file, if any, and stops serving metrics.
+// Failing to write the file does not fail the operation.
+func (s *AsertoPlugin) closeMetrics() {
+	if !s.ownsMetrics {
+		return
+	}
+
+	if s.Config.MetricsFile != "" {
+		if err := s.metrics.WriteFile(s.Config.MetricsFile); err != nil {
+			log.Printf("write metrics file: %s", err)
+		}
+	}
+
+	s.metrics.Stop()
+	s.metrics, s.ownsMetrics = nil, false
+}
+
+// connectionOptions adds the stats handler counting retries and reconnects,
+// for the summary and the metrics, to the options connecting to the
+// authorizer.
+func (s *AsertoPlugin) connectionOptions() []aserto.ConnectionOption {
+	if s.connStats == nil {
+		return s.connOptions
+	}
+
+	opts := append([]aserto.ConnectionOption{}, s.connOptions...)
+	return append(opts, aserto.WithDialOptions(grpc.WithStatsHandler(s.connStats)))
+}
@@ -0,0 +1,75 @@
+package srv
+
+import (
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/config"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/progress"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/tracing"
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// openProgress starts reporting progress when the config sets an interval or
+// a callback is set. The plugins of a tenants file share the reporter of the
+// plugin opening them.
+func (s *AsertoPlugin) openProgress(conf *config.AsertoConfig, operation plugin.OperationType) {
+	if s.progress != nil || (conf.ProgressInterval == 0 && s.OnProgress == nil) {
+		return
+	}
+
+	s.progress = progress.New(operationName(operation), conf.ProgressReportInterval(), s.OnProgress)
+	s.progress.SetTotal(conf.ProgressTotal)
+	s.ownsProgress = true
+}
+
+// closeProgress stops reporting progress, with a final report.
+func (s *AsertoPlugin) closeProgress() {
+	if !s.ownsProgress {
+		return
+	}
+
+	s.progress.Finish()
+	s.progress, s.ownsProgress = nil, false
+}
+
+// countError counts a user that could not be read or written when err is set.
+func (s *AsertoPlugin) countError(err error) {
+	if err != nil {
+		s.errorCount++
+		s.progress.AddError()
+	}
+}
+
+// CountUsers pages through the users of the directory, without their
+// extensions, and returns how many there are. It is meant to run before
+// reading the users, to report progress against the total.
+func (s *AsertoPlugin) CountUsers() (count int, err error) {
+	ctx, span := s.tracing.Start(s.ctx, "Count users")
+	defer func() {
+		span.SetAttributes(attribute.Int("users", count))
+		tracing.End(span, err)
+	}()
+
+	token := ""
+	for {
+		callCtx, cancel := s.callContext(ctx)
+		resp, err := s.dirClient.ListUsers(callCtx, &dir.ListUsersRequest{
+			Page: &api.PaginationRequest{
+				Size:  pageSize,
+				Token: token,
+			},
+			Base: true,
+		})
+		cancel()
+		if err != nil {
+			return 0, err
+		}
+
+		count += len(resp.Results)
+		token = resp.Page.NextToken
+		if token == "" {
+			return count, nil
+		}
+	}
+}
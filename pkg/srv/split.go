@@ -0,0 +1,129 @@
+package srv
+
+import (
+	"strings"
+
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/config"
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	sectionRoles       = "roles"
+	sectionPermissions = "permissions"
+	sectionProperties  = "properties"
+)
+
+// splitOptions controls which parts of a user are moved into its UserExt when
+// extensions are split. The zero value moves every attribute section and every
+// application.
+type splitOptions struct {
+	applications map[string]bool
+	sections     map[string]bool
+	provider     string
+	keepBase     bool
+}
+
+func newSplitOptions(conf *config.AsertoConfig) splitOptions {
+	return splitOptions{
+		applications: parseList(conf.SplitApplications),
+		sections:     parseList(conf.SplitAttributes),
+		provider:     conf.SplitProvider,
+		keepBase:     conf.SplitKeepBase,
+	}
+}
+
+// parseList turns a comma separated list into a set. An empty list yields nil,
+// meaning everything is selected.
+func parseList(list string) map[string]bool {
+	var set map[string]bool
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if set == nil {
+			set = make(map[string]bool)
+		}
+		set[item] = true
+	}
+
+	return set
+}
+
+func (o *splitOptions) includesApplication(app string) bool {
+	return o.applications == nil || o.applications[app]
+}
+
+func (o *splitOptions) includesSection(section string) bool {
+	return o.sections == nil || o.sections[section]
+}
+
+// extension builds the UserExt load request for the user, keyed by its PID.
+// Unless keepBase is set, the parts moved into the extension are cleared on
+// the user itself.
+func (o *splitOptions) extension(user *api.User) (*dir.LoadUsersRequest, error) {
+	pid := findPID(user, o.provider)
+	if pid == "" {
+		if o.provider != "" {
+			return nil, status.Errorf(codes.Internal, "couldn't find %s PID identity for user: %s", o.provider, user.DisplayName)
+		}
+		return nil, status.Errorf(codes.Internal, "couldn't find PID identity for user: %s", user.DisplayName)
+	}
+
+	ext := &api.UserExt{
+		Id:           pid,
+		Attributes:   o.splitAttributes(user),
+		Applications: make(map[string]*api.AttrSet),
+	}
+
+	for app, attrs := range user.Applications {
+		if !o.includesApplication(app) {
+			continue
+		}
+		ext.Applications[app] = proto.Clone(attrs).(*api.AttrSet)
+		if !o.keepBase {
+			delete(user.Applications, app)
+		}
+	}
+
+	return &dir.LoadUsersRequest{
+		Data: &dir.LoadUsersRequest_UserExt{
+			UserExt: ext,
+		},
+	}, nil
+}
+
+func (o *splitOptions) splitAttributes(user *api.User) *api.AttrSet {
+	attrs := &api.AttrSet{}
+	if user.Attributes == nil {
+		return attrs
+	}
+
+	if o.includesSection(sectionRoles) {
+		attrs.Roles = append([]string{}, user.Attributes.Roles...)
+		if !o.keepBase {
+			user.Attributes.Roles = nil
+		}
+	}
+
+	if o.includesSection(sectionPermissions) {
+		attrs.Permissions = append([]string{}, user.Attributes.Permissions...)
+		if !o.keepBase {
+			user.Attributes.Permissions = nil
+		}
+	}
+
+	if o.includesSection(sectionProperties) && user.Attributes.Properties != nil {
+		attrs.Properties = proto.Clone(user.Attributes.Properties).(*structpb.Struct)
+		if !o.keepBase {
+			user.Attributes.Properties = nil
+		}
+	}
+
+	return attrs
+}
@@ -0,0 +1,89 @@
+package srv
+
+import (
+	"testing"
+
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func createSplitTestUser() *api.User {
+	user := CreateTestAPIUser("1", "auth0|1", "First Last", "test@unit.com", "0998976834", "connectionId")
+	user.Identities["okta|1"] = &api.IdentitySource{
+		Kind:     api.IdentityKind_IDENTITY_KIND_PID,
+		Provider: "okta",
+		Verified: true,
+	}
+	user.Attributes.Permissions = []string{"read"}
+	user.Applications["app1"] = &api.AttrSet{Roles: []string{"viewer"}}
+	user.Applications["app2"] = &api.AttrSet{Roles: []string{"editor"}}
+
+	return user
+}
+
+func TestSplitDefaults(t *testing.T) {
+	assert := require.New(t)
+	opts := splitOptions{}
+	user := createSplitTestUser()
+
+	req, err := opts.extension(user)
+
+	assert.Nil(err)
+	ext := req.GetUserExt()
+	assert.Equal("auth0|1", ext.Id)
+	assert.Equal([]string{"User"}, ext.Attributes.Roles)
+	assert.Equal([]string{"read"}, ext.Attributes.Permissions)
+	assert.Len(ext.Applications, 2)
+	assert.Empty(user.Attributes.Roles)
+	assert.Empty(user.Attributes.Permissions)
+	assert.Nil(user.Attributes.Properties)
+	assert.Empty(user.Applications)
+}
+
+func TestSplitSelected(t *testing.T) {
+	assert := require.New(t)
+	opts := splitOptions{
+		applications: parseList("app1"),
+		sections:     parseList("roles"),
+		provider:     "okta",
+	}
+	user := createSplitTestUser()
+
+	req, err := opts.extension(user)
+
+	assert.Nil(err)
+	ext := req.GetUserExt()
+	assert.Equal("okta|1", ext.Id)
+	assert.Equal([]string{"User"}, ext.Attributes.Roles)
+	assert.Empty(ext.Attributes.Permissions)
+	assert.Contains(ext.Applications, "app1")
+	assert.NotContains(ext.Applications, "app2")
+	assert.Empty(user.Attributes.Roles)
+	assert.Equal([]string{"read"}, user.Attributes.Permissions)
+	assert.NotContains(user.Applications, "app1")
+	assert.Contains(user.Applications, "app2")
+}
+
+func TestSplitKeepBase(t *testing.T) {
+	assert := require.New(t)
+	opts := splitOptions{keepBase: true}
+	user := createSplitTestUser()
+
+	req, err := opts.extension(user)
+
+	assert.Nil(err)
+	assert.Len(req.GetUserExt().Applications, 2)
+	assert.Equal([]string{"User"}, user.Attributes.Roles)
+	assert.Len(user.Applications, 2)
+}
+
+func TestSplitMissingProvider(t *testing.T) {
+	assert := require.New(t)
+	opts := splitOptions{provider: "azure"}
+	user := createSplitTestUser()
+
+	_, err := opts.extension(user)
+
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = Internal desc = couldn't find azure PID identity for user: First Last", err.Error())
+}
@@ -2,22 +2,29 @@ package srv
 
 import (
 	"context"
+	"errors"
 	"io"
 	"log"
+	"sort"
 	"time"
 
 	aserto "github.com/aserto-dev/aserto-go/client"
-	"github.com/aserto-dev/aserto-go/client/authorizer"
 	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/config"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/filedir"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/metrics"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/progress"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/tracing"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/transform"
 	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
 	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
 	"github.com/aserto-dev/idp-plugin-sdk/plugin"
 	"github.com/google/uuid"
 	"github.com/tidwall/gjson"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
-	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -26,15 +33,49 @@ const (
 )
 
 type AsertoPlugin struct {
-	Config          *config.AsertoConfig
+	Config *config.AsertoConfig
+	// OnProgress, when set, receives the progress reports instead of the log.
+	OnProgress func(*progress.Event)
+
 	dirClient       dir.DirectoryClient
 	ctx             context.Context
+	cancel          context.CancelFunc
+	rpcTimeout      time.Duration
 	token           string
 	lastPage        bool
 	loadUsersStream dir.Directory_LoadUsersClient
+	streamCancel    context.CancelFunc
 	sendCount       int32
+	extSendCount    int32
+	pagesRead       int
+	usersRead       int
+	errorCount      int
+	started         time.Time
+	connStats       *metrics.ConnStats
+	summary         *Summary
+	closed          bool
+	tenantSummaries []*Summary
+	inTenants       bool
 	op              plugin.OperationType
 	splitExtensions bool
+	splitOptions    splitOptions
+	generateIDs     bool
+	tenantID        string
+	mergeIdentities bool
+	mergePrecedence string
+	transforms      *transform.Pipeline
+	roleMap         *transform.RoleMap
+	roleMappingMode string
+	connOptions     []aserto.ConnectionOption
+	conn            *config.Connection
+	tenants         []*tenantPlugin
+	metrics         *metrics.Metrics
+	ownsMetrics     bool
+	tracing         *tracing.Tracing
+	ownsTracing     bool
+	span            trace.Span
+	progress        *progress.Reporter
+	ownsProgress    bool
 }
 
 func NewAuth0Plugin() *AsertoPlugin {
@@ -57,59 +98,135 @@ func (s *AsertoPlugin) Open(cfg plugin.Config, operation plugin.OperationType) e
 		return status.Errorf(codes.InvalidArgument, "invalid config")
 	}
 	s.Config = conf
+	s.op = operation
+	s.started = time.Now()
+	s.closed = false
 
-	s.ctx = context.Background()
+	if err := s.openMetrics(conf); err != nil {
+		return err
+	}
+	if err := s.openTracing(conf); err != nil {
+		return err
+	}
+
+	s.openProgress(conf, operation)
+
+	if conf.TenantsFile != "" {
+		return s.openTenants(conf, operation)
+	}
+
+	if deadline := conf.OperationDeadline(); deadline > 0 {
+		s.ctx, s.cancel = context.WithTimeout(context.Background(), deadline)
+	} else {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	}
+	s.ctx, s.span = s.tracing.Start(s.ctx, operationName(operation), attribute.String("tenant", conf.Tenant))
+	s.rpcTimeout = conf.RPCTimeout()
 
-	var client *authorizer.Client
 	var err error
-	if conf.Insecure {
-		client, err = authorizer.New(
-			s.ctx,
-			aserto.WithAddr(conf.Authorizer),
-			aserto.WithTenantID(conf.Tenant),
-			aserto.WithInsecure(conf.Insecure),
-		)
+	if conf.File != "" {
+		s.dirClient, err = openFile(conf.File, operation)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "open file: %s", err.Error())
+		}
 	} else {
-		client, err = authorizer.New(
-			s.ctx,
-			aserto.WithAddr(conf.Authorizer),
-			aserto.WithAPIKeyAuth(conf.APIKey),
-			aserto.WithTenantID(conf.Tenant),
-		)
+		s.connStats = s.metrics.StatsHandler()
+		s.conn, err = conf.Connect(s.ctx, s.connectionOptions()...)
+		if err != nil {
+			var optsErr *config.OptionsError
+			if errors.As(err, &optsErr) {
+				return status.Errorf(codes.InvalidArgument, "invalid connection options: %s", err.Error())
+			}
+			return status.Errorf(codes.Internal, "failed to create authorizer connection: %s", err.Error())
+		}
+		s.dirClient = s.conn.Directory
 	}
+	s.dirClient = s.tracing.Instrument(s.metrics.Instrument(s.dirClient))
 
-	if err != nil {
-		log.Fatalf("Failed to create authorizer connection: %s", err)
+	if conf.TransformFile != "" {
+		s.transforms, err = transform.Load(conf.TransformFile)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "load transform file: %s", err.Error())
+		}
+	}
+
+	if conf.RoleMapping != "" {
+		s.roleMap, err = transform.LoadRoleMap(conf.RoleMapping)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "load role mapping: %s", err.Error())
+		}
 	}
 
-	s.dirClient = client.Directory
 	s.lastPage = false
 	switch operation {
 	case plugin.OperationTypeWrite, plugin.OperationTypeDelete:
-		s.loadUsersStream, err = s.dirClient.LoadUsers(s.ctx)
+		var streamCtx context.Context
+		streamCtx, s.streamCancel = context.WithCancel(s.ctx)
+		err = s.streamCall("open stream", func() (err error) {
+			s.loadUsersStream, err = s.dirClient.LoadUsers(streamCtx)
+			return err
+		})
 		if err != nil {
 			return err
 		}
 	}
 
+	if operation == plugin.OperationTypeRead && conf.ProgressCount && s.progress != nil {
+		total, err := s.CountUsers()
+		if err != nil {
+			return status.Errorf(codes.Internal, "count users: %s", err.Error())
+		}
+		s.progress.SetTotal(total)
+	}
+
 	s.sendCount = 0
-	s.op = operation
+	s.extSendCount = 0
 	s.splitExtensions = conf.SplitExtensions
+	s.splitOptions = newSplitOptions(conf)
+	s.generateIDs = conf.GenerateIDs
+	s.tenantID = conf.Tenant
+	s.mergeIdentities = conf.MergeIdentities
+	s.mergePrecedence = conf.MergePrecedence
+	s.roleMappingMode = conf.RoleMappingMode
 
 	return nil
 }
 
+// openFile returns the offline directory client for file. Writes start from
+// an empty file, so that the result is a snapshot of the users written.
+func openFile(file string, operation plugin.OperationType) (dir.DirectoryClient, error) {
+	if operation == plugin.OperationTypeWrite {
+		return filedir.Create(file)
+	}
+
+	return filedir.Open(file)
+}
+
 func (s *AsertoPlugin) Read() ([]*api.User, error) {
+	users, err := s.readPage(s.ctx)
+	switch {
+	case err == io.EOF:
+	case err != nil:
+		s.countError(err)
+	default:
+		// Only pages returned to the host count as read, not the pages
+		// looked up by deletes.
+		s.metrics.PageRead(len(users))
+		s.pagesRead++
+		s.usersRead += len(users)
+		s.progress.AddRead(len(users))
+	}
+
+	return users, err
+}
+
+// readPage reads the next page of users and applies the read transforms.
+func (s *AsertoPlugin) readPage(ctx context.Context) ([]*api.User, error) {
 	if s.lastPage {
 		return nil, io.EOF
 	}
-	resp, err := s.dirClient.ListUsers(s.ctx, &dir.ListUsersRequest{
-		Page: &api.PaginationRequest{
-			Size:  pageSize,
-			Token: s.token,
-		},
-		Base: false,
-	})
+
+	resp, err := s.listPage(ctx, s.token)
 	if err != nil {
 		return nil, err
 	}
@@ -120,44 +237,59 @@ func (s *AsertoPlugin) Read() ([]*api.User, error) {
 
 	s.token = resp.Page.NextToken
 
+	for _, user := range resp.Results {
+		if err := s.transforms.Apply(user, transform.PhaseRead); err != nil {
+			return nil, status.Errorf(codes.Internal, "transform user %s: %s", user.Id, err.Error())
+		}
+	}
+
 	return resp.Results, nil
 }
 
-func (s *AsertoPlugin) Write(user *api.User) error {
+func (s *AsertoPlugin) Write(user *api.User) (err error) {
+	if s.tenants != nil {
+		return s.writeTenants(user)
+	}
+	defer func() { s.countError(err) }()
 
-	var reqExt *dir.LoadUsersRequest
-	if s.splitExtensions {
-		clonedAttributes := proto.Clone(user.Attributes)
-		user.Attributes = &api.AttrSet{}
+	if err := s.ctx.Err(); err != nil {
+		return status.Errorf(status.FromContextError(err).Code(), "write user %s: %s", user.Id, err.Error())
+	}
 
-		clonedApplications := make(map[string]*api.AttrSet)
-		for k, v := range user.Applications {
-			cloneAttrSet := proto.Clone(v)
-			clonedApplications[k] = cloneAttrSet.(*api.AttrSet)
-		}
-		user.Applications = make(map[string]*api.AttrSet)
+	if err := s.transforms.Apply(user, transform.PhaseWrite); err != nil {
+		return status.Errorf(codes.Internal, "transform user %s: %s", user.Id, err.Error())
+	}
 
-		var pid string
+	if s.generateIDs {
+		pid := findPID(user, "")
+		if pid == "" {
+			return status.Errorf(codes.InvalidArgument, "couldn't find PID identity for user: %s", user.DisplayName)
+		}
+		user.Id = generateUserID(s.tenantID, pid)
+	}
 
-		for key, value := range user.Identities {
-			if value.Kind == api.IdentityKind_IDENTITY_KIND_PID {
-				pid = key
-				break
-			}
+	if s.roleMap != nil {
+		if err := s.mapRoles(user); err != nil {
+			return err
 		}
+	}
 
-		if pid == "" {
-			return status.Errorf(codes.Internal, "couldn't find PID identity for user: %s", user.DisplayName)
+	if s.mergeIdentities {
+		existing, err := s.findExistingUser(user)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			user = mergeUsers(existing, user, s.mergePrecedence)
 		}
+	}
 
-		reqExt = &dir.LoadUsersRequest{
-			Data: &dir.LoadUsersRequest_UserExt{
-				UserExt: &api.UserExt{
-					Id:           pid,
-					Attributes:   clonedAttributes.(*api.AttrSet),
-					Applications: clonedApplications,
-				},
-			},
+	var reqExt *dir.LoadUsersRequest
+	if s.splitExtensions {
+		var err error
+		reqExt, err = s.splitOptions.extension(user)
+		if err != nil {
+			return err
 		}
 	}
 
@@ -175,45 +307,183 @@ func (s *AsertoPlugin) Write(user *api.User) error {
 		if err := s.loadUsersStream.Send(reqExt); err != nil {
 			return status.Errorf(codes.Internal, "stream send extension: %s", err.Error())
 		}
+		s.extSendCount++
 	}
 
 	s.sendCount++
+	s.progress.AddWritten(1)
 
 	return nil
 }
 
-func (s *AsertoPlugin) Delete(userID string) error {
+func (s *AsertoPlugin) Delete(userID string) (err error) {
+	if s.tenants != nil {
+		return s.deleteTenants(userID)
+	}
+	defer func() { s.countError(err) }()
+
+	if err := s.ctx.Err(); err != nil {
+		return status.Errorf(status.FromContextError(err).Code(), "delete user %s: %s", userID, err.Error())
+	}
+
+	ctx, span := s.tracing.Start(s.ctx, "Delete lookup", attribute.String("user.query", userID))
+	deleteUsers, err := s.lookupUsers(ctx, userID)
+	span.SetAttributes(attribute.Int("users.matched", len(deleteUsers)))
+	tracing.End(span, err)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range deleteUsers {
+		user.Deleted = true
+		if user.Metadata == nil {
+			user.Metadata = &api.Metadata{}
+		}
+		user.Metadata.DeletedAt = timestamppb.New(time.Now())
+		req := &dir.LoadUsersRequest{
+			Data: &dir.LoadUsersRequest_User{
+				User: user,
+			},
+		}
+
+		if err := s.loadUsersStream.Send(req); err != nil {
+			return status.Errorf(codes.Internal, "stream send: %s", err.Error())
+		}
+		s.sendCount++
+		s.progress.AddWritten(1)
+	}
+
+	return nil
+}
+
+func (s *AsertoPlugin) Close() (stats *plugin.Stats, err error) {
+	// Closing again, such as from a deferred call, does nothing.
+	if s.closed {
+		return nil, nil
+	}
+	s.closed = true
+
+	defer s.closeMetrics()
+	defer s.closeTracing()
+	defer s.closeProgress()
+	defer func() { s.closeSummary(stats, err) }()
+
+	if s.tenants != nil {
+		return s.closeTenants()
+	}
+
+	if s.cancel != nil {
+		defer s.cancel()
+	}
+
+	if s.conn != nil {
+		defer s.closeConn()
+	}
+
+	switch s.op {
+	case plugin.OperationTypeWrite, plugin.OperationTypeDelete:
+		// The host closes a plugin that failed to open, possibly before the
+		// stream was opened.
+		if s.ctx == nil || s.loadUsersStream == nil {
+			return nil, nil
+		}
+
+		// A canceled stream was already torn down, there is nothing left to receive.
+		if err := s.ctx.Err(); err != nil {
+			return nil, status.Errorf(status.FromContextError(err).Code(), "stream close: %s", err.Error())
+		}
+
+		var res *dir.LoadUsersResponse
+		err := s.streamCall("stream close", func() (err error) {
+			res, err = s.loadUsersStream.CloseAndRecv()
+			return err
+		})
+		switch {
+		case status.Code(err) == codes.DeadlineExceeded:
+			return nil, err
+		case err != nil:
+			return nil, status.Errorf(codes.Internal, "stream close: %s", err.Error())
+		}
+
+		if res != nil {
+			return &plugin.Stats{
+				Received: res.Received,
+				Created:  res.Created,
+				Updated:  res.Updated,
+				Deleted:  res.Deleted,
+				Errors:   res.Errors,
+			}, nil
+		}
+
+	}
+
+	return nil, nil
+}
+
+// listPage lists the page of users at token in a span of its own, child of
+// the span of ctx.
+func (s *AsertoPlugin) listPage(ctx context.Context, token string) (resp *dir.ListUsersResponse, err error) {
+	ctx, span := s.tracing.Start(ctx, "Read page", attribute.Bool("page.first", token == ""))
+	defer func() {
+		span.SetAttributes(attribute.Int("users", len(resp.GetResults())))
+		tracing.End(span, err)
+	}()
+
+	ctx, cancel := s.callContext(ctx)
+	defer cancel()
+
+	return s.dirClient.ListUsers(ctx, &dir.ListUsersRequest{
+		Page: &api.PaginationRequest{
+			Size:  pageSize,
+			Token: token,
+		},
+		Base: false,
+	})
+}
+
+// lookupUsers returns the users to delete for userID, either the user with
+// that ID or the users matching it as a gjson path. Users are matched and
+// deleted as stored, without the read transforms.
+func (s *AsertoPlugin) lookupUsers(ctx context.Context, userID string) ([]*api.User, error) {
 	var deleteUsers []*api.User
 	if isValidUUID(userID) {
 		req := &dir.GetUserRequest{
 			Id: userID,
 		}
 
-		resp, err := s.dirClient.GetUser(s.ctx, req)
+		ctx, cancel := s.callContext(ctx)
+		resp, err := s.dirClient.GetUser(ctx, req)
+		cancel()
 		if err != nil {
-			return status.Errorf(codes.Internal, "get user: %s", err.Error())
+			return nil, status.Errorf(codes.Internal, "get user: %s", err.Error())
 		}
 
 		user := resp.GetResult()
 		if user == nil {
-			return status.Errorf(codes.NotFound, "user %s not found", userID)
+			return nil, status.Errorf(codes.NotFound, "user %s not found", userID)
 		}
 
 		deleteUsers = append(deleteUsers, user)
 	} else {
 		var allUsers []*api.User
+		token := ""
 		for {
-			u, err := s.Read()
-			if err == io.EOF {
+			resp, err := s.listPage(ctx, token)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "list users: %s", err.Error())
+			}
+			allUsers = append(allUsers, resp.Results...)
+
+			token = resp.Page.GetNextToken()
+			if token == "" {
 				break
 			}
-			allUsers = append(allUsers, u...)
 		}
 
 		for _, u := range allUsers {
 			userJSON, err := protojson.Marshal(u)
 			if err != nil {
-				return status.Errorf(codes.Internal, "unmarshal user: %s", err.Error())
+				return nil, status.Errorf(codes.Internal, "unmarshal user: %s", err.Error())
 			}
 			userStr := string(userJSON)
 			result := gjson.Get("["+userStr+"]", userID)
@@ -224,48 +494,104 @@ func (s *AsertoPlugin) Delete(userID string) error {
 		}
 	}
 
-	for _, user := range deleteUsers {
-		user.Deleted = true
-		user.Metadata.DeletedAt = timestamppb.New(time.Now())
-		req := &dir.LoadUsersRequest{
-			Data: &dir.LoadUsersRequest_User{
-				User: user,
-			},
-		}
+	return deleteUsers, nil
+}
 
-		if err := s.loadUsersStream.Send(req); err != nil {
-			return status.Errorf(codes.Internal, "stream send: %s", err.Error())
-		}
-		s.sendCount++
+// closeConn closes the connection to the authorizer once the operation is done.
+func (s *AsertoPlugin) closeConn() {
+	if err := s.conn.Close(); err != nil {
+		log.Printf("close authorizer connection: %s", err)
 	}
+	s.conn = nil
+}
 
-	return nil
+// rpcContext returns the context for a single call to the directory, bounded
+// by the configured timeout and canceled with the operation.
+func (s *AsertoPlugin) rpcContext() (context.Context, context.CancelFunc) {
+	return s.callContext(s.ctx)
 }
 
-func (s *AsertoPlugin) Close() (*plugin.Stats, error) {
-	switch s.op {
-	case plugin.OperationTypeWrite, plugin.OperationTypeDelete:
-		res, err := s.loadUsersStream.CloseAndRecv()
-		if err != nil {
-			return nil, status.Errorf(codes.Internal, "stream close: %s", err.Error())
-		}
+// callContext bounds ctx, derived from the operation context, by the
+// configured timeout of a single call.
+func (s *AsertoPlugin) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.rpcTimeout <= 0 {
+		return ctx, func() {}
+	}
 
-		if res != nil {
-			return &plugin.Stats{
-				Received: res.Received,
-				Created:  res.Created,
-				Updated:  res.Updated,
-				Deleted:  res.Deleted,
-				Errors:   res.Errors,
-			}, nil
-		}
+	return context.WithTimeout(ctx, s.rpcTimeout)
+}
 
+// streamCall runs call on the users stream, canceling the stream when call
+// does not return within the configured timeout of a single call.
+func (s *AsertoPlugin) streamCall(name string, call func() error) error {
+	if s.rpcTimeout <= 0 {
+		return call()
 	}
 
-	return nil, nil
+	timer := time.AfterFunc(s.rpcTimeout, s.streamCancel)
+	err := call()
+	if !timer.Stop() {
+		return status.Errorf(codes.DeadlineExceeded, "%s: no response after %s", name, s.rpcTimeout)
+	}
+
+	return err
+}
+
+// mapRoles applies the role mapping to the user. Unless the mapping replaces
+// roles, the user as stored in the directory is fetched to merge with.
+func (s *AsertoPlugin) mapRoles(user *api.User) error {
+	var existing *api.User
+	if s.roleMappingMode != transform.RoleMappingReplace && user.Id != "" {
+		ctx, cancel := s.rpcContext()
+		resp, err := s.dirClient.GetUser(ctx, &dir.GetUserRequest{Id: user.Id})
+		cancel()
+		switch {
+		case status.Code(err) == codes.NotFound:
+		case err != nil:
+			return status.Errorf(codes.Internal, "get user: %s", err.Error())
+		default:
+			existing = resp.GetResult()
+		}
+	}
+
+	s.roleMap.Apply(user, existing, s.roleMappingMode)
+	return nil
 }
 
 func isValidUUID(u string) bool {
 	_, err := uuid.Parse(u)
 	return err == nil
 }
+
+// findPID returns the lowest sorting PID identity key of the user, so that
+// users with several PIDs always resolve to the same one. A non-empty
+// provider only considers PIDs issued by that provider.
+func findPID(user *api.User, provider string) string {
+	var pids []string
+	for key, value := range user.Identities {
+		if value.Kind != api.IdentityKind_IDENTITY_KIND_PID {
+			continue
+		}
+		if provider == "" || value.Provider == provider {
+			pids = append(pids, key)
+		}
+	}
+
+	if len(pids) == 0 {
+		return ""
+	}
+
+	sort.Strings(pids)
+	return pids[0]
+}
+
+// generateUserID derives a UUIDv5 from the tenant ID and the PID identity key.
+// Tenant IDs that are not UUIDs are hashed into a namespace first.
+func generateUserID(tenantID, pid string) string {
+	namespace, err := uuid.Parse(tenantID)
+	if err != nil {
+		namespace = uuid.NewSHA1(uuid.Nil, []byte(tenantID))
+	}
+
+	return uuid.NewSHA1(namespace, []byte(pid)).String()
+}
@@ -3,10 +3,14 @@ package srv
 import (
 	"errors"
 	"io"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/config"
 	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/mocks"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/transform"
 	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
 	directory "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
 	"github.com/aserto-dev/idp-plugin-sdk/plugin"
@@ -206,6 +210,78 @@ func TestWriteSplitExtFail(t *testing.T) {
 	assert.Equal(int32(0), p.sendCount)
 }
 
+func TestWriteGenerateID(t *testing.T) {
+	assert := require.New(t)
+	p := NewTestAsertoPlugin(gomock.NewController(t), plugin.OperationTypeWrite)
+	p.generateIDs = true
+	p.tenantID = "a1b2c3d4-0000-11ec-b5cf-02a489f227f9"
+	user1 := CreateTestAPIUser("1", "auth0|123", "First Last", "test@unit.com", "0998976834", "connectionId")
+	user2 := CreateTestAPIUser("2", "auth0|123", "First Last", "test@unit.com", "0998976834", "connectionId")
+
+	p.loadUsersStream.(*mocks.MockDirectory_LoadUsersClient).EXPECT().Send(gomock.Any()).Times(2).Return(nil)
+
+	assert.Nil(p.Write(user1))
+	assert.Nil(p.Write(user2))
+	assert.True(isValidUUID(user1.Id))
+	assert.Equal(user1.Id, user2.Id)
+	assert.Equal(generateUserID(p.tenantID, "auth0|123"), user1.Id)
+	assert.NotEqual(generateUserID("other-tenant", "auth0|123"), user1.Id)
+}
+
+func TestWriteGenerateIDNoPID(t *testing.T) {
+	assert := require.New(t)
+	p := NewTestAsertoPlugin(gomock.NewController(t), plugin.OperationTypeWrite)
+	p.generateIDs = true
+	p.tenantID = "tenantID"
+	user := CreateTestAPIUser("1", "", "First Last", "test@unit.com", "0998976834", "connectionId")
+	delete(user.Identities, "")
+
+	err := p.Write(user)
+
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = couldn't find PID identity for user: First Last", err.Error())
+	assert.Equal(int32(0), p.sendCount)
+}
+
+func TestWriteRoleMappingMerge(t *testing.T) {
+	assert := require.New(t)
+	p := NewTestAsertoPlugin(gomock.NewController(t), plugin.OperationTypeWrite)
+	roleMap, err := transform.LoadRoleMap(`{"mappings": [{"property": "groups", "groups": {"eng": {"roles": ["developer"]}}}]}`)
+	assert.Nil(err)
+	p.roleMap = roleMap
+	user := CreateTestAPIUser("1", "1", "First Last", "test@unit.com", "0998976834", "connectionId")
+	user.Attributes.Properties.Fields["groups"] = structpb.NewStringValue("eng")
+	existing := CreateTestAPIUser("1", "1", "First Last", "test@unit.com", "0998976834", "connectionId")
+	existing.Attributes.Roles = []string{"auditor"}
+
+	p.dirClient.(*mocks.MockDirectoryClient).EXPECT().GetUser(p.ctx, gomock.Any()).Return(
+		&directory.GetUserResponse{Result: existing}, nil)
+	p.loadUsersStream.(*mocks.MockDirectory_LoadUsersClient).EXPECT().Send(gomock.Any()).Return(nil)
+
+	err = p.Write(user)
+
+	assert.Nil(err)
+	assert.Equal([]string{"User", "auditor", "developer"}, user.Attributes.Roles)
+}
+
+func TestWriteRoleMappingReplace(t *testing.T) {
+	assert := require.New(t)
+	p := NewTestAsertoPlugin(gomock.NewController(t), plugin.OperationTypeWrite)
+	roleMap, err := transform.LoadRoleMap(`{"mappings": [{"property": "groups", "groups": {"eng": {"roles": ["developer"]}}}]}`)
+	assert.Nil(err)
+	p.roleMap = roleMap
+	p.roleMappingMode = transform.RoleMappingReplace
+	user := CreateTestAPIUser("1", "1", "First Last", "test@unit.com", "0998976834", "connectionId")
+	user.Attributes.Properties.Fields["groups"] = structpb.NewStringValue("eng")
+
+	p.loadUsersStream.(*mocks.MockDirectory_LoadUsersClient).EXPECT().Send(gomock.Any()).Return(nil)
+
+	err = p.Write(user)
+
+	assert.Nil(err)
+	assert.Equal([]string{"developer"}, user.Attributes.Roles)
+}
+
 func TestClose(t *testing.T) {
 	assert := require.New(t)
 	p := NewTestAsertoPlugin(gomock.NewController(t), plugin.OperationTypeWrite)
@@ -219,6 +295,40 @@ func TestClose(t *testing.T) {
 	assert.Equal(int32(1), res.Received)
 }
 
+func TestCloseTwice(t *testing.T) {
+	assert := require.New(t)
+	p := NewTestAsertoPlugin(gomock.NewController(t), plugin.OperationTypeWrite)
+	p.sendCount = 1
+
+	p.loadUsersStream.(*mocks.MockDirectory_LoadUsersClient).EXPECT().CloseAndRecv().Return(&directory.LoadUsersResponse{Received: 1}, nil)
+
+	_, err := p.Close()
+	assert.Nil(err)
+	summary := p.summary
+
+	res, err := p.Close()
+	assert.Nil(err)
+	assert.Nil(res)
+	assert.Same(summary, p.summary)
+}
+
+func TestCloseFlagsMismatch(t *testing.T) {
+	assert := require.New(t)
+	p := NewTestAsertoPlugin(gomock.NewController(t), plugin.OperationTypeWrite)
+	p.sendCount = 2
+	p.extSendCount = 2
+
+	p.loadUsersStream.(*mocks.MockDirectory_LoadUsersClient).EXPECT().CloseAndRecv().Return(&directory.LoadUsersResponse{Received: 3, Created: 2}, nil)
+
+	_, err := p.Close()
+	assert.Nil(err)
+	assert.Equal("Import", p.summary.Operation)
+	assert.Equal(2, p.summary.UsersSent)
+	assert.Equal(2, p.summary.ExtensionsSent)
+	assert.Equal(int32(2), p.summary.Server.Created)
+	assert.Equal("sent 4 messages but the directory received 3", p.summary.Mismatch)
+}
+
 func TestCloseWithStreamClose(t *testing.T) {
 	assert := require.New(t)
 	p := NewTestAsertoPlugin(gomock.NewController(t), plugin.OperationTypeWrite)
@@ -233,6 +343,42 @@ func TestCloseWithStreamClose(t *testing.T) {
 	assert.Equal("rpc error: code = Internal desc = stream close: #boom#", err.Error(), "should return error")
 }
 
+func TestCloseAfterFailedOpen(t *testing.T) {
+	for _, operation := range []plugin.OperationType{plugin.OperationTypeRead, plugin.OperationTypeWrite, plugin.OperationTypeDelete} {
+		assert := require.New(t)
+		dir := t.TempDir()
+		file := filepath.Join(dir, "users.jsonl")
+		assert.NoError(os.WriteFile(file, nil, 0600))
+		cfg := &config.AsertoConfig{File: file, TransformFile: filepath.Join(dir, "missing.yaml")}
+
+		p := NewAsertoPlugin()
+		assert.NotNil(p.Open(cfg, operation))
+
+		stats, err := p.Close()
+		assert.Nil(err)
+		assert.Nil(stats)
+		assert.Equal(operationName(operation), p.summary.Operation)
+	}
+}
+
+func TestCloseAfterFailedOpenStopsMetrics(t *testing.T) {
+	assert := require.New(t)
+	dir := t.TempDir()
+	cfg := &config.AsertoConfig{
+		File:          filepath.Join(dir, "users.jsonl"),
+		TransformFile: filepath.Join(dir, "missing.yaml"),
+		MetricsAddr:   "127.0.0.1:0",
+	}
+
+	p := NewAsertoPlugin()
+	assert.NotNil(p.Open(cfg, plugin.OperationTypeWrite))
+	assert.NotNil(p.metrics)
+
+	_, err := p.Close()
+	assert.Nil(err)
+	assert.Nil(p.metrics)
+}
+
 func TestDeleteFail(t *testing.T) {
 	assert := require.New(t)
 	p := NewTestAsertoPlugin(gomock.NewController(t), plugin.OperationTypeWrite)
@@ -309,3 +455,49 @@ func TestDeleteWithQuery(t *testing.T) {
 	assert.Nil(err)
 	assert.Equal(int32(1), p.sendCount)
 }
+
+func TestDeleteWithQueryIgnoresReadTransforms(t *testing.T) {
+	assert := require.New(t)
+	p := NewTestAsertoPlugin(gomock.NewController(t), plugin.OperationTypeDelete)
+	transforms, err := transform.New([]transform.Rule{
+		{Op: transform.OpTemplate, Target: "email", Phase: transform.PhaseRead, Template: "exported-{{ .Value }}"},
+	})
+	assert.Nil(err)
+	p.transforms = transforms
+
+	users := []*api.User{CreateTestAPIUser("1", "1", "First Last", "test@unit.com", "0998976834", "connectionId")}
+	p.dirClient.(*mocks.MockDirectoryClient).EXPECT().ListUsers(gomock.Any(), gomock.Any()).Return(
+		CreateListResp("", users), nil).Times(2)
+	p.loadUsersStream.(*mocks.MockDirectory_LoadUsersClient).EXPECT().Send(gomock.Any()).DoAndReturn(
+		func(req *directory.LoadUsersRequest) error {
+			assert.Equal("test@unit.com", req.GetUser().Email)
+			assert.True(req.GetUser().Deleted)
+			return nil
+		}).Times(2)
+
+	assert.Nil(p.Delete(`#(email=="test@unit.com")`))
+	assert.Nil(p.Delete(`#(email=="test@unit.com")`))
+	assert.Equal(int32(2), p.sendCount)
+}
+
+func TestOfflineFile(t *testing.T) {
+	assert := require.New(t)
+	cfg := &config.AsertoConfig{File: filepath.Join(t.TempDir(), "users.jsonl")}
+
+	writer := NewAsertoPlugin()
+	assert.Nil(writer.Open(cfg, plugin.OperationTypeWrite))
+	assert.Nil(writer.Write(CreateTestAPIUser("1", "auth0|1", "First Last", "test@unit.com", "0998976834", "connectionId")))
+	assert.Nil(writer.Write(CreateTestAPIUser("2", "auth0|2", "First2 Last2", "test2@unit.com", "0998976835", "connectionId")))
+	stats, err := writer.Close()
+	assert.Nil(err)
+	assert.Equal(int32(2), stats.Created)
+
+	reader := NewAsertoPlugin()
+	assert.Nil(reader.Open(cfg, plugin.OperationTypeRead))
+	users, err := reader.Read()
+	assert.Nil(err)
+	assert.Len(users, 2)
+	assert.Equal("First2 Last2", users[1].DisplayName)
+	_, err = reader.Read()
+	assert.Equal(io.EOF, err)
+}
@@ -0,0 +1,164 @@
+package srv
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+)
+
+// Summary describes an operation once the plugin is closed. It is logged, and
+// written as JSON to the report file, if any.
+type Summary struct {
+	Operation       string       `json:"operation"`
+	Tenant          string       `json:"tenant,omitempty"`
+	Started         time.Time    `json:"started"`
+	DurationSeconds float64      `json:"duration_seconds"`
+	PagesRead       int          `json:"pages_read"`
+	UsersRead       int          `json:"users_read"`
+	UsersSent       int          `json:"users_sent"`
+	ExtensionsSent  int          `json:"extensions_sent"`
+	Errors          int          `json:"errors"`
+	Retries         int64        `json:"retries"`
+	Reconnects      int64        `json:"reconnects"`
+	Server          *ServerStats `json:"server,omitempty"`
+	Mismatch        string       `json:"mismatch,omitempty"`
+	Error           string       `json:"error,omitempty"`
+	Tenants         []*Summary   `json:"tenants,omitempty"`
+}
+
+// ServerStats are the stats returned by the directory for the users loaded.
+type ServerStats struct {
+	Received int32 `json:"received"`
+	Created  int32 `json:"created"`
+	Updated  int32 `json:"updated"`
+	Deleted  int32 `json:"deleted"`
+	Errors   int32 `json:"errors"`
+}
+
+// MessagesSent is the number of users and extensions sent to the directory.
+func (s *Summary) MessagesSent() int {
+	return s.UsersSent + s.ExtensionsSent
+}
+
+func (s *Summary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s", strings.ToLower(s.Operation))
+	if s.Tenant != "" {
+		fmt.Fprintf(&b, " of tenant %s", s.Tenant)
+	}
+	fmt.Fprintf(&b, " took %s: read %d users in %d pages, sent %d users and %d extensions, %d errors, %d retries, %d reconnects",
+		time.Duration(s.DurationSeconds*float64(time.Second)).Round(time.Millisecond),
+		s.UsersRead, s.PagesRead, s.UsersSent, s.ExtensionsSent, s.Errors, s.Retries, s.Reconnects)
+
+	if s.Server != nil {
+		fmt.Fprintf(&b, "; directory received %d, created %d, updated %d, deleted %d, errors %d",
+			s.Server.Received, s.Server.Created, s.Server.Updated, s.Server.Deleted, s.Server.Errors)
+	}
+
+	return b.String()
+}
+
+// add adds the counts of a tenant to the summary of a tenants file.
+func (s *Summary) add(tenant *Summary) {
+	s.Tenants = append(s.Tenants, tenant)
+	s.PagesRead += tenant.PagesRead
+	s.UsersRead += tenant.UsersRead
+	s.UsersSent += tenant.UsersSent
+	s.ExtensionsSent += tenant.ExtensionsSent
+	s.Errors += tenant.Errors
+	s.Retries += tenant.Retries
+	s.Reconnects += tenant.Reconnects
+}
+
+// setServerStats records the stats returned by the directory and flags a
+// count of messages received that differs from the count sent.
+func (s *Summary) setServerStats(stats *plugin.Stats) {
+	if stats == nil {
+		return
+	}
+
+	s.Server = &ServerStats{
+		Received: stats.Received,
+		Created:  stats.Created,
+		Updated:  stats.Updated,
+		Deleted:  stats.Deleted,
+		Errors:   stats.Errors,
+	}
+
+	if sent := s.MessagesSent(); int(stats.Received) != sent {
+		s.Mismatch = fmt.Sprintf("sent %d messages but the directory received %d", sent, stats.Received)
+	}
+}
+
+// newSummary sums up the operation from the local counts and, for writes and
+// deletes, the stats returned by the directory.
+func (s *AsertoPlugin) newSummary(stats *plugin.Stats, err error) *Summary {
+	summary := &Summary{
+		Operation:      operationName(s.op),
+		Started:        s.started,
+		PagesRead:      s.pagesRead,
+		UsersRead:      s.usersRead,
+		UsersSent:      int(s.sendCount),
+		ExtensionsSent: int(s.extSendCount),
+		Errors:         s.errorCount,
+	}
+	switch {
+	case s.inTenants:
+		summary.Tenant = s.Config.Name()
+	case s.Config != nil:
+		summary.Tenant = s.Config.Tenant
+	}
+	if !s.started.IsZero() {
+		summary.DurationSeconds = time.Since(s.started).Seconds()
+	}
+	if s.connStats != nil {
+		summary.Retries = s.connStats.Retries()
+		summary.Reconnects = s.connStats.Reconnects()
+	}
+
+	for _, tenant := range s.tenantSummaries {
+		summary.add(tenant)
+	}
+
+	summary.setServerStats(stats)
+	if err != nil {
+		summary.Error = err.Error()
+	}
+
+	return summary
+}
+
+// closeSummary sums up the operation, then logs the summary and writes the
+// report file, unless the plugin is one of the tenants of a tenants file.
+// Failing to write the report does not fail the operation.
+func (s *AsertoPlugin) closeSummary(stats *plugin.Stats, err error) {
+	s.summary = s.newSummary(stats, err)
+	if s.inTenants {
+		return
+	}
+
+	log.Printf("summary: %s", s.summary)
+	if s.summary.Mismatch != "" {
+		log.Printf("summary: %s", s.summary.Mismatch)
+	}
+
+	if s.Config != nil && s.Config.ReportFile != "" {
+		if err := writeReport(s.Config.ReportFile, s.summary); err != nil {
+			log.Printf("write report file: %s", err)
+		}
+	}
+}
+
+func writeReport(path string, summary *Summary) error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
@@ -0,0 +1,121 @@
+package srv
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/config"
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// tenantPlugin writes to one of the tenants of a tenants file.
+type tenantPlugin struct {
+	name   string
+	plugin *AsertoPlugin
+	errors int32
+}
+
+// openTenants opens a plugin, and so a LoadUsers stream, for every tenant of
+// the tenants file.
+func (s *AsertoPlugin) openTenants(conf *config.AsertoConfig, operation plugin.OperationType) error {
+	if operation == plugin.OperationTypeRead {
+		return status.Error(codes.InvalidArgument, "a tenants file can only be used to write or delete users")
+	}
+
+	configs, err := conf.TenantConfigs()
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "load tenants file: %s", err.Error())
+	}
+
+	// Every tenant is sent every user.
+	s.progress.SetTotal(conf.ProgressTotal * len(configs))
+
+	s.tenants = nil
+	for _, tenantConf := range configs {
+		// The summary of the tenants file covers every tenant.
+		tenantConf.ReportFile = ""
+
+		p := &AsertoPlugin{connOptions: s.connOptions, metrics: s.metrics, tracing: s.tracing, progress: s.progress, inTenants: true}
+		if err := p.Open(tenantConf, operation); err != nil {
+			s.closeTenants()
+			return status.Errorf(status.Code(err), "tenant %s: %s", tenantConf.Name(), status.Convert(err).Message())
+		}
+
+		s.tenants = append(s.tenants, &tenantPlugin{name: tenantConf.Name(), plugin: p})
+	}
+
+	s.op = operation
+	return nil
+}
+
+// writeTenants writes a copy of user to every tenant, so that the transforms
+// of one tenant do not affect the others.
+func (s *AsertoPlugin) writeTenants(user *api.User) error {
+	return s.eachTenant(func(t *tenantPlugin) error {
+		return t.plugin.Write(proto.Clone(user).(*api.User))
+	})
+}
+
+func (s *AsertoPlugin) deleteTenants(userID string) error {
+	return s.eachTenant(func(t *tenantPlugin) error {
+		return t.plugin.Delete(userID)
+	})
+}
+
+// eachTenant calls fn for every tenant, carrying on past failures, and
+// reports the failures of all tenants at once.
+func (s *AsertoPlugin) eachTenant(fn func(t *tenantPlugin) error) error {
+	var failures []string
+	for _, t := range s.tenants {
+		if err := fn(t); err != nil {
+			t.errors++
+			failures = append(failures, fmt.Sprintf("tenant %s: %s", t.name, err.Error()))
+		}
+	}
+
+	if len(failures) > 0 {
+		return status.Error(codes.Internal, strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+// closeTenants closes every tenant and sums up their stats and summaries.
+// Failures are reported per tenant, after all tenants were closed.
+func (s *AsertoPlugin) closeTenants() (*plugin.Stats, error) {
+	total := &plugin.Stats{}
+	var failures []string
+	s.tenantSummaries = nil
+	for _, t := range s.tenants {
+		stats, err := t.plugin.Close()
+		s.tenantSummaries = append(s.tenantSummaries, t.plugin.summary)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("tenant %s: %s", t.name, err.Error()))
+			continue
+		}
+		if stats == nil {
+			continue
+		}
+
+		log.Printf("tenant %s: received %d, created %d, updated %d, deleted %d, errors %d, failed sends %d",
+			t.name, stats.Received, stats.Created, stats.Updated, stats.Deleted, stats.Errors, t.errors)
+
+		total.Received += stats.Received
+		total.Created += stats.Created
+		total.Updated += stats.Updated
+		total.Deleted += stats.Deleted
+		total.Errors += stats.Errors
+	}
+	s.tenants = nil
+
+	if len(failures) > 0 {
+		return total, status.Errorf(codes.Internal, "close tenants: %s", strings.Join(failures, "; "))
+	}
+
+	return total, nil
+}
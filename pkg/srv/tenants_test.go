@@ -0,0 +1,112 @@
+package srv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/config"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/filedir"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTenantsFile(t *testing.T, dir, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, "tenants.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	return path
+}
+
+func TestTenantsWrite(t *testing.T) {
+	assert := require.New(t)
+	dir := t.TempDir()
+	assert.NoError(os.WriteFile(filepath.Join(dir, "acme.yaml"), []byte(`
+rules:
+  - op: template
+    target: display_name
+    template: "ACME {{ .Value }}"
+`), 0600))
+
+	cfg := &config.AsertoConfig{TenantsFile: writeTenantsFile(t, dir, `
+tenants:
+  - file: `+filepath.Join(dir, "acme.jsonl")+`
+    transform-file: `+filepath.Join(dir, "acme.yaml")+`
+  - file: `+filepath.Join(dir, "globex.jsonl")+`
+`)}
+	assert.NoError(cfg.Validate(plugin.OperationTypeWrite))
+
+	p := NewAsertoPlugin()
+	assert.Nil(p.Open(cfg, plugin.OperationTypeWrite))
+	assert.Len(p.tenants, 2)
+	assert.Nil(p.Write(CreateTestAPIUser("1", "auth0|1", "First Last", "test@unit.com", "0998976834", "connectionId")))
+	assert.Nil(p.Write(CreateTestAPIUser("2", "auth0|2", "Second Last", "test2@unit.com", "0998976835", "connectionId")))
+
+	stats, err := p.Close()
+	assert.Nil(err)
+	assert.Equal(int32(4), stats.Received)
+	assert.Equal(int32(4), stats.Created)
+	assert.Len(p.summary.Tenants, 2)
+	assert.Equal(filepath.Join(dir, "acme.jsonl"), p.summary.Tenants[0].Tenant)
+	assert.Equal(4, p.summary.UsersSent)
+	assert.Equal(int32(4), p.summary.Server.Received)
+	assert.Empty(p.summary.Mismatch)
+
+	acme, err := filedir.ReadFile(filepath.Join(dir, "acme.jsonl"))
+	assert.NoError(err)
+	assert.Len(acme, 2)
+	assert.Equal("ACME First Last", acme[0].DisplayName)
+
+	globex, err := filedir.ReadFile(filepath.Join(dir, "globex.jsonl"))
+	assert.NoError(err)
+	assert.Len(globex, 2)
+	assert.Equal("First Last", globex[0].DisplayName)
+}
+
+func TestTenantsWriteFailurePerTenant(t *testing.T) {
+	assert := require.New(t)
+	dir := t.TempDir()
+
+	cfg := &config.AsertoConfig{TenantsFile: writeTenantsFile(t, dir, `
+tenants:
+  - file: `+filepath.Join(dir, "acme.jsonl")+`
+    generate-ids: true
+    tenant: acme
+  - file: `+filepath.Join(dir, "globex.jsonl")+`
+`)}
+
+	p := NewAsertoPlugin()
+	assert.Nil(p.Open(cfg, plugin.OperationTypeWrite))
+
+	user := CreateTestAPIUser("1", "auth0|1", "First Last", "test@unit.com", "0998976834", "connectionId")
+	user.Identities = nil
+	err := p.Write(user)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "tenant "+filepath.Join(dir, "acme.jsonl")+": ")
+	assert.NotContains(err.Error(), "globex")
+
+	stats, err := p.Close()
+	assert.Nil(err)
+	assert.Equal(int32(1), stats.Received)
+}
+
+func TestTenantsValidate(t *testing.T) {
+	assert := require.New(t)
+	dir := t.TempDir()
+
+	cfg := &config.AsertoConfig{TenantsFile: writeTenantsFile(t, dir, `
+tenants:
+  - tenant: acme
+    authorizer: localhost:8282
+`)}
+
+	err := cfg.Validate(plugin.OperationTypeWrite)
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = tenant acme: no api key was provided", err.Error())
+
+	err = cfg.Validate(plugin.OperationTypeRead)
+	assert.NotNil(err)
+	assert.Equal("rpc error: code = InvalidArgument desc = a tenants file can only be used to write or delete users", err.Error())
+}
@@ -0,0 +1,74 @@
+package srv
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/config"
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/tracing"
+	"github.com/aserto-dev/idp-plugin-sdk/plugin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const tracingShutdownTimeout = 5 * time.Second
+
+// openTracing creates the tracer of the configured exporter, if any. The
+// plugins of a tenants file share the tracer of the plugin opening them.
+func (s *AsertoPlugin) openTracing(conf *config.AsertoConfig) error {
+	if s.tracing != nil || conf.TracingExporter == "" {
+		return nil
+	}
+
+	version, _, _ := config.GetVersion()
+	t, err := tracing.New(context.Background(), &tracing.Options{
+		Exporter: conf.TracingExporter,
+		Endpoint: conf.TracingEndpoint,
+		Insecure: conf.TracingInsecure,
+		File:     conf.TracingFile,
+		Version:  version,
+	})
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "create tracer: %s", err.Error())
+	}
+
+	s.tracing = t
+	s.ownsTracing = true
+	return nil
+}
+
+// closeTracing ends the span of the operation and exports the spans not
+// exported yet. Failing to export them does not fail the operation.
+func (s *AsertoPlugin) closeTracing() {
+	if s.span != nil {
+		s.span.End()
+		s.span = nil
+	}
+
+	if !s.ownsTracing {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	if err := s.tracing.Shutdown(ctx); err != nil {
+		log.Printf("export spans: %s", err)
+	}
+
+	s.tracing, s.ownsTracing = nil, false
+}
+
+// operationName names the span of a whole operation.
+func operationName(operation plugin.OperationType) string {
+	switch operation {
+	case plugin.OperationTypeRead:
+		return "Export"
+	case plugin.OperationTypeWrite:
+		return "Import"
+	case plugin.OperationTypeDelete:
+		return "Delete"
+	}
+
+	return "Operation"
+}
@@ -0,0 +1,124 @@
+package tracing
+
+import (
+	"context"
+
+	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+const directoryService = "aserto.authorizer.directory.v1.Directory"
+
+// Instrument returns a directory client creating a client span for every call
+// made through client, and passing the trace context on in the call metadata.
+// It returns client itself on a nil *Tracing.
+func (t *Tracing) Instrument(client dir.DirectoryClient) dir.DirectoryClient {
+	if t == nil {
+		return client
+	}
+
+	return &tracedClient{DirectoryClient: client, tracing: t}
+}
+
+// startCall starts the client span of a call to method and injects it into
+// the outgoing metadata of the returned context.
+func (t *Tracing) startCall(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx, span := t.tracer.Start(ctx, directoryService+"/"+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.RPCSystemGRPC, semconv.RPCServiceKey.String(directoryService), semconv.RPCMethodKey.String(method)),
+		trace.WithAttributes(attrs...),
+	)
+
+	return t.inject(ctx), span
+}
+
+type tracedClient struct {
+	dir.DirectoryClient
+	tracing *Tracing
+}
+
+func (c *tracedClient) ListUsers(ctx context.Context, in *dir.ListUsersRequest, opts ...grpc.CallOption) (*dir.ListUsersResponse, error) {
+	ctx, span := c.tracing.startCall(ctx, "ListUsers",
+		attribute.Int("page.size", int(in.GetPage().GetSize())),
+		attribute.Bool("page.first", in.GetPage().GetToken() == ""),
+	)
+
+	resp, err := c.DirectoryClient.ListUsers(ctx, in, opts...)
+	if err == nil {
+		span.SetAttributes(attribute.Int("users", len(resp.Results)))
+	}
+	End(span, err)
+
+	return resp, err
+}
+
+func (c *tracedClient) GetUser(ctx context.Context, in *dir.GetUserRequest, opts ...grpc.CallOption) (*dir.GetUserResponse, error) {
+	ctx, span := c.tracing.startCall(ctx, "GetUser", attribute.String("user.id", in.GetId()))
+
+	resp, err := c.DirectoryClient.GetUser(ctx, in, opts...)
+	End(span, err)
+
+	return resp, err
+}
+
+func (c *tracedClient) GetIdentity(ctx context.Context, in *dir.GetIdentityRequest, opts ...grpc.CallOption) (*dir.GetIdentityResponse, error) {
+	ctx, span := c.tracing.startCall(ctx, "GetIdentity")
+
+	resp, err := c.DirectoryClient.GetIdentity(ctx, in, opts...)
+	End(span, err)
+
+	return resp, err
+}
+
+// LoadUsers starts a span lasting until the stream is closed.
+func (c *tracedClient) LoadUsers(ctx context.Context, opts ...grpc.CallOption) (dir.Directory_LoadUsersClient, error) {
+	ctx, span := c.tracing.startCall(ctx, "LoadUsers")
+
+	stream, err := c.DirectoryClient.LoadUsers(ctx, opts...)
+	if err != nil {
+		End(span, err)
+		return nil, err
+	}
+
+	span.AddEvent("stream opened")
+	return &tracedStream{Directory_LoadUsersClient: stream, span: span}, nil
+}
+
+type tracedStream struct {
+	dir.Directory_LoadUsersClient
+	span trace.Span
+	sent int
+}
+
+func (s *tracedStream) Send(req *dir.LoadUsersRequest) error {
+	err := s.Directory_LoadUsersClient.Send(req)
+	if err != nil {
+		s.span.RecordError(err)
+		return err
+	}
+
+	s.sent++
+	return nil
+}
+
+func (s *tracedStream) CloseAndRecv() (*dir.LoadUsersResponse, error) {
+	s.span.AddEvent("stream closing", trace.WithAttributes(attribute.Int("messages.sent", s.sent)))
+
+	resp, err := s.Directory_LoadUsersClient.CloseAndRecv()
+	s.span.SetAttributes(attribute.Int("messages.sent", s.sent))
+	if err == nil && resp != nil {
+		s.span.SetAttributes(
+			attribute.Int("users.received", int(resp.Received)),
+			attribute.Int("users.created", int(resp.Created)),
+			attribute.Int("users.updated", int(resp.Updated)),
+			attribute.Int("users.deleted", int(resp.Deleted)),
+			attribute.Int("users.errors", int(resp.Errors)),
+		)
+	}
+	End(s.span, err)
+
+	return resp, err
+}
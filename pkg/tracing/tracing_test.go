@@ -0,0 +1,144 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aserto-dev/aserto-idp-plugin-aserto/pkg/mocks"
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	dir "github.com/aserto-dev/go-grpc/aserto/authorizer/directory/v1"
+	gomock "github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func newTestTracing() (*Tracing, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	return newTracing(recorder, "test", nil), recorder
+}
+
+func attributes(kvs []attribute.KeyValue) map[attribute.Key]attribute.Value {
+	result := map[attribute.Key]attribute.Value{}
+	for _, kv := range kvs {
+		result[kv.Key] = kv.Value
+	}
+
+	return result
+}
+
+func TestInstrumentCalls(t *testing.T) {
+	assert := require.New(t)
+	ctrl := gomock.NewController(t)
+	client := mocks.NewMockDirectoryClient(ctrl)
+	tr, recorder := newTestTracing()
+
+	ctx, parent := tr.Start(context.Background(), "Read page")
+
+	client.EXPECT().ListUsers(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, _ *dir.ListUsersRequest, _ ...grpc.CallOption) (*dir.ListUsersResponse, error) {
+			md, ok := metadata.FromOutgoingContext(ctx)
+			assert.True(ok)
+			assert.Len(md.Get("traceparent"), 1)
+			assert.Contains(md.Get("traceparent")[0], parent.SpanContext().TraceID().String())
+			return &dir.ListUsersResponse{Results: []*api.User{{Id: "1"}}}, nil
+		})
+	client.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(nil, status.Error(codes.NotFound, "user 2 not found"))
+
+	instrumented := tr.Instrument(client)
+	_, err := instrumented.ListUsers(ctx, &dir.ListUsersRequest{Page: &api.PaginationRequest{Size: 100}})
+	assert.NoError(err)
+	_, err = instrumented.GetUser(ctx, &dir.GetUserRequest{Id: "2"})
+	assert.Error(err)
+	parent.End()
+
+	spans := recorder.Ended()
+	assert.Len(spans, 3)
+
+	list := spans[0]
+	assert.Equal(directoryService+"/ListUsers", list.Name())
+	assert.Equal(trace.SpanKindClient, list.SpanKind())
+	assert.Equal(parent.SpanContext().SpanID(), list.Parent().SpanID())
+	assert.Equal(int64(1), attributes(list.Attributes())["users"].AsInt64())
+	assert.Equal(otelcodes.Unset, list.Status().Code)
+
+	get := spans[1]
+	assert.Equal(directoryService+"/GetUser", get.Name())
+	assert.Equal(otelcodes.Error, get.Status().Code)
+	assert.Equal("user 2 not found", get.Status().Description)
+	assert.Equal(int64(codes.NotFound), attributes(get.Attributes())["rpc.grpc.status_code"].AsInt64())
+}
+
+func TestInstrumentLoadUsers(t *testing.T) {
+	assert := require.New(t)
+	ctrl := gomock.NewController(t)
+	client := mocks.NewMockDirectoryClient(ctrl)
+	stream := mocks.NewMockDirectory_LoadUsersClient(ctrl)
+	tr, recorder := newTestTracing()
+
+	client.EXPECT().LoadUsers(gomock.Any()).Return(stream, nil)
+	stream.EXPECT().Send(gomock.Any()).Return(nil).Times(2)
+	stream.EXPECT().CloseAndRecv().Return(&dir.LoadUsersResponse{Received: 2, Created: 1, Updated: 1}, nil)
+
+	s, err := tr.Instrument(client).LoadUsers(context.Background())
+	assert.NoError(err)
+	assert.NoError(s.Send(&dir.LoadUsersRequest{}))
+	assert.NoError(s.Send(&dir.LoadUsersRequest{}))
+	assert.Len(recorder.Ended(), 0)
+
+	_, err = s.CloseAndRecv()
+	assert.NoError(err)
+
+	spans := recorder.Ended()
+	assert.Len(spans, 1)
+	assert.Equal(directoryService+"/LoadUsers", spans[0].Name())
+	attrs := attributes(spans[0].Attributes())
+	assert.Equal(int64(2), attrs["messages.sent"].AsInt64())
+	assert.Equal(int64(1), attrs["users.created"].AsInt64())
+	assert.Len(spans[0].Events(), 2)
+}
+
+func TestNilTracing(t *testing.T) {
+	assert := require.New(t)
+	var tr *Tracing
+
+	client := mocks.NewMockDirectoryClient(gomock.NewController(t))
+	assert.Equal(dir.DirectoryClient(client), tr.Instrument(client))
+
+	ctx := context.Background()
+	spanCtx, span := tr.Start(ctx, "Read page")
+	assert.Equal(ctx, spanCtx)
+	assert.False(span.IsRecording())
+	End(span, status.Error(codes.Internal, "boom"))
+	assert.NoError(tr.Shutdown(ctx))
+}
+
+func TestFileExporter(t *testing.T) {
+	assert := require.New(t)
+	path := filepath.Join(t.TempDir(), "spans.json")
+
+	tr, err := New(context.Background(), &Options{Exporter: ExporterFile, File: path, Version: "1.2.3"})
+	assert.NoError(err)
+
+	_, span := tr.Start(context.Background(), "Import", attribute.String("tenant", "unit"))
+	span.End()
+	assert.NoError(tr.Shutdown(context.Background()))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(err)
+	assert.Contains(string(data), `"Name":"Import"`)
+	assert.Contains(string(data), `"Value":"1.2.3"`)
+}
+
+func TestInvalidExporter(t *testing.T) {
+	_, err := New(context.Background(), &Options{Exporter: "jaeger"})
+	require.EqualError(t, err, `invalid exporter "jaeger"`)
+}
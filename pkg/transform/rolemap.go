@@ -0,0 +1,167 @@
+package transform
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	RoleMappingMerge   = "merge"
+	RoleMappingReplace = "replace"
+)
+
+// Grant is the set of roles and permissions given to members of a group.
+type Grant struct {
+	Roles       []string `json:"roles,omitempty" yaml:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty" yaml:"permissions,omitempty"`
+}
+
+// RoleMapping grants roles and permissions based on the values of a user
+// property, such as a groups array. Application selects the attribute set of
+// an application instead of the global user attributes.
+type RoleMapping struct {
+	Property    string           `json:"property" yaml:"property"`
+	Application string           `json:"application,omitempty" yaml:"application,omitempty"`
+	Groups      map[string]Grant `json:"groups" yaml:"groups"`
+}
+
+// RoleMap is a table of role mappings. A nil RoleMap maps nothing.
+type RoleMap struct {
+	Mappings []RoleMapping `json:"mappings" yaml:"mappings"`
+}
+
+// LoadRoleMap parses source as an inline JSON object, or reads it as the path
+// to a YAML or JSON file otherwise.
+func LoadRoleMap(source string) (*RoleMap, error) {
+	data := []byte(source)
+	if !strings.HasPrefix(strings.TrimSpace(source), "{") {
+		var err error
+		data, err = os.ReadFile(source)
+		if err != nil {
+			return nil, fmt.Errorf("read role mapping file: %w", err)
+		}
+	}
+
+	m := &RoleMap{}
+	if err := yaml.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("parse role mapping: %w", err)
+	}
+
+	for i, mapping := range m.Mappings {
+		if mapping.Property == "" {
+			return nil, fmt.Errorf("mapping %d: no property was provided", i+1)
+		}
+	}
+
+	return m, nil
+}
+
+// Apply grants the user the roles and permissions mapped from its properties.
+// In replace mode the mapped values replace the roles and permissions of the
+// targeted attribute set. In merge mode they are added to the user's own
+// values and to those of existing, the user as currently stored in the
+// directory, which may be nil.
+func (m *RoleMap) Apply(user, existing *api.User, mode string) {
+	if m == nil || user == nil {
+		return
+	}
+
+	// Mappings targeting the same attribute set add up, so that in replace
+	// mode a mapping does not drop what another one granted.
+	grants := map[string]*Grant{}
+	var apps []string
+	for _, mapping := range m.Mappings {
+		grant, ok := grants[mapping.Application]
+		if !ok {
+			grant = &Grant{}
+			grants[mapping.Application] = grant
+			apps = append(apps, mapping.Application)
+		}
+
+		for _, group := range propertyValues(user, mapping.Property) {
+			g, ok := mapping.Groups[group]
+			if !ok {
+				continue
+			}
+			grant.Roles = appendMissing(grant.Roles, g.Roles...)
+			grant.Permissions = appendMissing(grant.Permissions, g.Permissions...)
+		}
+	}
+
+	for _, app := range apps {
+		// Users in no mapped group keep their attribute sets as they are.
+		grant := grants[app]
+		if len(grant.Roles) == 0 && len(grant.Permissions) == 0 {
+			continue
+		}
+
+		attrs := attrSet(user, app)
+		if mode == RoleMappingReplace {
+			attrs.Roles = grant.Roles
+			attrs.Permissions = grant.Permissions
+			continue
+		}
+
+		if stored := storedAttrSet(existing, app); stored != nil {
+			attrs.Roles = appendMissing(attrs.Roles, stored.Roles...)
+			attrs.Permissions = appendMissing(attrs.Permissions, stored.Permissions...)
+		}
+		attrs.Roles = appendMissing(attrs.Roles, grant.Roles...)
+		attrs.Permissions = appendMissing(attrs.Permissions, grant.Permissions...)
+	}
+}
+
+// propertyValues returns the string values of a user property holding either
+// a single string or a list of strings.
+func propertyValues(user *api.User, property string) []string {
+	value, ok := user.GetAttributes().GetProperties().GetFields()[property]
+	if !ok {
+		return nil
+	}
+
+	if s := value.GetStringValue(); s != "" {
+		return []string{s}
+	}
+
+	var values []string
+	for _, v := range value.GetListValue().GetValues() {
+		if s := v.GetStringValue(); s != "" {
+			values = append(values, s)
+		}
+	}
+
+	return values
+}
+
+func attrSet(user *api.User, app string) *api.AttrSet {
+	if app == "" {
+		if user.Attributes == nil {
+			user.Attributes = &api.AttrSet{}
+		}
+		return user.Attributes
+	}
+
+	if user.Applications == nil {
+		user.Applications = make(map[string]*api.AttrSet)
+	}
+	if user.Applications[app] == nil {
+		user.Applications[app] = &api.AttrSet{}
+	}
+
+	return user.Applications[app]
+}
+
+func storedAttrSet(user *api.User, app string) *api.AttrSet {
+	if user == nil {
+		return nil
+	}
+	if app == "" {
+		return user.Attributes
+	}
+
+	return user.Applications[app]
+}
@@ -0,0 +1,111 @@
+package transform
+
+import (
+	"testing"
+
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const testRoleMap = `{
+	"mappings": [
+		{
+			"property": "groups",
+			"groups": {
+				"eng": {"roles": ["developer"], "permissions": ["deploy"]},
+				"ops": {"roles": ["operator", "developer"]}
+			}
+		},
+		{
+			"property": "team",
+			"application": "portal",
+			"groups": {"blue": {"roles": ["portal-admin"]}}
+		}
+	]
+}`
+
+func createGroupUser() *api.User {
+	groups, _ := structpb.NewList([]interface{}{"eng", "ops", "unknown"})
+	return &api.User{
+		Id: "1",
+		Attributes: &api.AttrSet{
+			Properties: &structpb.Struct{Fields: map[string]*structpb.Value{
+				"groups": structpb.NewListValue(groups),
+				"team":   structpb.NewStringValue("blue"),
+			}},
+			Roles: []string{"user"},
+		},
+	}
+}
+
+func TestRoleMapMerge(t *testing.T) {
+	assert := require.New(t)
+	m, err := LoadRoleMap(testRoleMap)
+	assert.Nil(err)
+
+	existing := &api.User{Id: "1", Attributes: &api.AttrSet{Roles: []string{"auditor"}}}
+	user := createGroupUser()
+	m.Apply(user, existing, RoleMappingMerge)
+
+	assert.Equal([]string{"user", "auditor", "developer", "operator"}, user.Attributes.Roles)
+	assert.Equal([]string{"deploy"}, user.Attributes.Permissions)
+	assert.Equal([]string{"portal-admin"}, user.Applications["portal"].Roles)
+}
+
+func TestRoleMapReplace(t *testing.T) {
+	assert := require.New(t)
+	m, err := LoadRoleMap(testRoleMap)
+	assert.Nil(err)
+
+	user := createGroupUser()
+	m.Apply(user, nil, RoleMappingReplace)
+
+	assert.Equal([]string{"developer", "operator"}, user.Attributes.Roles)
+	assert.Equal([]string{"deploy"}, user.Attributes.Permissions)
+}
+
+func TestRoleMapReplaceSameApplication(t *testing.T) {
+	assert := require.New(t)
+	m, err := LoadRoleMap(`{
+		"mappings": [
+			{"property": "groups", "application": "portal", "groups": {"eng": {"roles": ["developer"], "permissions": ["deploy"]}}},
+			{"property": "team", "application": "portal", "groups": {"blue": {"roles": ["portal-admin"]}}}
+		]
+	}`)
+	assert.Nil(err)
+
+	user := createGroupUser()
+	user.Applications = map[string]*api.AttrSet{"portal": {Roles: []string{"viewer"}}}
+	m.Apply(user, nil, RoleMappingReplace)
+
+	assert.Equal([]string{"developer", "portal-admin"}, user.Applications["portal"].Roles)
+	assert.Equal([]string{"deploy"}, user.Applications["portal"].Permissions)
+}
+
+func TestRoleMapReplaceUnmappedGroup(t *testing.T) {
+	assert := require.New(t)
+	m, err := LoadRoleMap(`{
+		"mappings": [
+			{"property": "groups", "groups": {"admins": {"roles": ["admin"]}}},
+			{"property": "groups", "application": "portal", "groups": {"admins": {"roles": ["portal-admin"]}}}
+		]
+	}`)
+	assert.Nil(err)
+
+	user := createGroupUser()
+	m.Apply(user, nil, RoleMappingReplace)
+
+	assert.Equal([]string{"user"}, user.Attributes.Roles)
+	assert.Nil(user.Applications)
+}
+
+func TestLoadRoleMapInvalid(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := LoadRoleMap(`{"mappings": [{"groups": {}}]}`)
+	assert.EqualError(err, "mapping 1: no property was provided")
+
+	_, err = LoadRoleMap("/does/not/exist.yaml")
+	assert.NotNil(err)
+}
@@ -0,0 +1,438 @@
+package transform
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	"google.golang.org/protobuf/types/known/structpb"
+	"gopkg.in/yaml.v3"
+)
+
+type Phase string
+
+const (
+	PhaseRead  Phase = "read"
+	PhaseWrite Phase = "write"
+)
+
+const (
+	OpSet      = "set"
+	OpAdd      = "add"
+	OpRemove   = "remove"
+	OpRename   = "rename"
+	OpMap      = "map"
+	OpTemplate = "template"
+)
+
+const (
+	fieldDisplayName = "display_name"
+	fieldEmail       = "email"
+	fieldRoles       = "roles"
+	fieldPermissions = "permissions"
+	fieldProperties  = "properties"
+)
+
+// Rule is a single transformation as written in a transform file.
+//
+// Target selects what the rule applies to: display_name, email,
+// attributes.<field> or applications.<app>.<field>, where field is roles,
+// permissions or properties.<key>. The application name * matches every
+// application of the user.
+type Rule struct {
+	Op       string            `json:"op" yaml:"op"`
+	Target   string            `json:"target" yaml:"target"`
+	Phase    Phase             `json:"phase,omitempty" yaml:"phase,omitempty"`
+	Value    interface{}       `json:"value,omitempty" yaml:"value,omitempty"`
+	Values   []string          `json:"values,omitempty" yaml:"values,omitempty"`
+	From     string            `json:"from,omitempty" yaml:"from,omitempty"`
+	To       string            `json:"to,omitempty" yaml:"to,omitempty"`
+	Mapping  map[string]string `json:"mapping,omitempty" yaml:"mapping,omitempty"`
+	Template string            `json:"template,omitempty" yaml:"template,omitempty"`
+}
+
+// File is the layout of a transform file. JSON files are accepted as well,
+// since they are valid YAML.
+type File struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// Pipeline is an ordered, validated list of rules. A nil Pipeline applies no rules.
+type Pipeline struct {
+	rules []*rule
+}
+
+type rule struct {
+	Rule
+	target   target
+	template *template.Template
+}
+
+type target struct {
+	field string
+	app   string
+	key   string
+	scope bool
+}
+
+// TemplateData is the value templates are executed against. Value holds the
+// current value of the targeted field, or the list element being rendered.
+type TemplateData struct {
+	Value       string
+	ID          string
+	DisplayName string
+	Email       string
+	Properties  map[string]interface{}
+}
+
+// Load reads and validates the transform file at path.
+func Load(path string) (*Pipeline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read transform file: %w", err)
+	}
+
+	var file File
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse transform file %s: %w", path, err)
+	}
+
+	return New(file.Rules)
+}
+
+// New validates rules and compiles their templates.
+func New(rules []Rule) (*Pipeline, error) {
+	p := &Pipeline{}
+	for i, r := range rules {
+		compiled, err := compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		p.rules = append(p.rules, compiled)
+	}
+
+	return p, nil
+}
+
+// Apply runs every rule that matches phase against the user, in order.
+func (p *Pipeline) Apply(user *api.User, phase Phase) error {
+	if p == nil || user == nil {
+		return nil
+	}
+
+	for i, r := range p.rules {
+		if r.Phase != "" && r.Phase != phase {
+			continue
+		}
+		if err := r.apply(user); err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+func compile(r Rule) (*rule, error) {
+	t, err := parseTarget(r.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	switch r.Phase {
+	case "", PhaseRead, PhaseWrite:
+	default:
+		return nil, fmt.Errorf("invalid phase %q", r.Phase)
+	}
+
+	compiled := &rule{Rule: r, target: t}
+
+	switch r.Op {
+	case OpSet, OpRemove, OpMap:
+	case OpAdd:
+		if t.field != fieldRoles && t.field != fieldPermissions {
+			return nil, fmt.Errorf("op %q requires a roles or permissions target", r.Op)
+		}
+	case OpRename:
+		if t.field == fieldDisplayName || t.field == fieldEmail {
+			return nil, fmt.Errorf("op %q cannot target %s", r.Op, t.field)
+		}
+		if r.To == "" {
+			return nil, fmt.Errorf("op %q requires 'to'", r.Op)
+		}
+		if t.field != fieldProperties && r.From == "" {
+			return nil, fmt.Errorf("op %q requires 'from'", r.Op)
+		}
+	case OpTemplate:
+		tmpl, err := template.New(r.Target).Option("missingkey=zero").Parse(r.Template)
+		if err != nil {
+			return nil, fmt.Errorf("parse template: %w", err)
+		}
+		compiled.template = tmpl
+	default:
+		return nil, fmt.Errorf("invalid op %q", r.Op)
+	}
+
+	return compiled, nil
+}
+
+func parseTarget(s string) (target, error) {
+	switch s {
+	case fieldDisplayName, fieldEmail:
+		return target{field: s}, nil
+	}
+
+	parts := strings.Split(s, ".")
+	var t target
+	switch {
+	case parts[0] == "attributes" && len(parts) >= 2:
+		parts = parts[1:]
+	case parts[0] == "applications" && len(parts) >= 3 && parts[1] != "":
+		t.scope = true
+		t.app = parts[1]
+		parts = parts[2:]
+	default:
+		return t, fmt.Errorf("invalid target %q", s)
+	}
+
+	t.field = parts[0]
+	switch t.field {
+	case fieldRoles, fieldPermissions:
+		if len(parts) != 1 {
+			return t, fmt.Errorf("invalid target %q", s)
+		}
+	case fieldProperties:
+		t.key = strings.Join(parts[1:], ".")
+		if t.key == "" {
+			return t, fmt.Errorf("target %q is missing a property key", s)
+		}
+	default:
+		return t, fmt.Errorf("invalid target %q", s)
+	}
+
+	return t, nil
+}
+
+func (r *rule) apply(user *api.User) error {
+	switch r.target.field {
+	case fieldDisplayName:
+		value, err := r.scalar(user, user.DisplayName)
+		user.DisplayName = value
+		return err
+	case fieldEmail:
+		value, err := r.scalar(user, user.Email)
+		user.Email = value
+		return err
+	}
+
+	for _, attrs := range r.attrSets(user) {
+		var err error
+		switch r.target.field {
+		case fieldRoles:
+			attrs.Roles, err = r.list(user, attrs.Roles)
+		case fieldPermissions:
+			attrs.Permissions, err = r.list(user, attrs.Permissions)
+		case fieldProperties:
+			err = r.property(user, attrs)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// attrSets returns the attribute sets selected by the rule target. Missing
+// sets are only created for rules that add values.
+func (r *rule) attrSets(user *api.User) []*api.AttrSet {
+	create := r.Op == OpSet || r.Op == OpAdd || r.Op == OpTemplate
+
+	if !r.target.scope {
+		if user.Attributes == nil {
+			if !create {
+				return nil
+			}
+			user.Attributes = &api.AttrSet{}
+		}
+		return []*api.AttrSet{user.Attributes}
+	}
+
+	if r.target.app == "*" {
+		sets := make([]*api.AttrSet, 0, len(user.Applications))
+		for _, attrs := range user.Applications {
+			if attrs != nil {
+				sets = append(sets, attrs)
+			}
+		}
+		return sets
+	}
+
+	attrs := user.Applications[r.target.app]
+	if attrs == nil {
+		if !create {
+			return nil
+		}
+		if user.Applications == nil {
+			user.Applications = make(map[string]*api.AttrSet)
+		}
+		attrs = &api.AttrSet{}
+		user.Applications[r.target.app] = attrs
+	}
+
+	return []*api.AttrSet{attrs}
+}
+
+func (r *rule) scalar(user *api.User, value string) (string, error) {
+	switch r.Op {
+	case OpSet:
+		return toString(r.Value), nil
+	case OpRemove:
+		return "", nil
+	case OpMap:
+		if mapped, ok := r.Mapping[value]; ok {
+			return mapped, nil
+		}
+		return value, nil
+	case OpTemplate:
+		return r.render(user, value)
+	}
+
+	return value, nil
+}
+
+func (r *rule) list(user *api.User, values []string) ([]string, error) {
+	switch r.Op {
+	case OpSet:
+		return append([]string{}, r.Values...), nil
+	case OpAdd:
+		return appendMissing(values, r.Values...), nil
+	case OpRemove:
+		if len(r.Values) == 0 {
+			return nil, nil
+		}
+		remove := make(map[string]bool, len(r.Values))
+		for _, v := range r.Values {
+			remove[v] = true
+		}
+		var result []string
+		for _, v := range values {
+			if !remove[v] {
+				result = append(result, v)
+			}
+		}
+		return result, nil
+	}
+
+	var result []string
+	for _, v := range values {
+		switch r.Op {
+		case OpRename:
+			if v == r.From {
+				v = r.To
+			}
+		case OpMap:
+			if mapped, ok := r.Mapping[v]; ok {
+				v = mapped
+			}
+		case OpTemplate:
+			rendered, err := r.render(user, v)
+			if err != nil {
+				return nil, err
+			}
+			v = rendered
+		}
+		if v != "" {
+			result = appendMissing(result, v)
+		}
+	}
+
+	return result, nil
+}
+
+func (r *rule) property(user *api.User, attrs *api.AttrSet) error {
+	if attrs.GetProperties().GetFields() == nil {
+		// Only set and template create properties, there is nothing to
+		// remove, rename or map.
+		if r.Op != OpSet && r.Op != OpTemplate {
+			return nil
+		}
+		if attrs.Properties == nil {
+			attrs.Properties = &structpb.Struct{}
+		}
+		attrs.Properties.Fields = make(map[string]*structpb.Value)
+	}
+	fields := attrs.Properties.Fields
+	current, exists := fields[r.target.key]
+
+	switch r.Op {
+	case OpSet:
+		value, err := structpb.NewValue(r.Value)
+		if err != nil {
+			return fmt.Errorf("property %s: %w", r.target.key, err)
+		}
+		fields[r.target.key] = value
+	case OpRemove:
+		delete(fields, r.target.key)
+	case OpRename:
+		if exists {
+			delete(fields, r.target.key)
+			fields[r.To] = current
+		}
+	case OpMap:
+		if mapped, ok := r.Mapping[current.GetStringValue()]; exists && ok {
+			fields[r.target.key] = structpb.NewStringValue(mapped)
+		}
+	case OpTemplate:
+		rendered, err := r.render(user, current.GetStringValue())
+		if err != nil {
+			return err
+		}
+		fields[r.target.key] = structpb.NewStringValue(rendered)
+	}
+
+	return nil
+}
+
+func (r *rule) render(user *api.User, value string) (string, error) {
+	data := TemplateData{
+		Value:       value,
+		ID:          user.Id,
+		DisplayName: user.DisplayName,
+		Email:       user.Email,
+		Properties:  user.GetAttributes().GetProperties().AsMap(),
+	}
+
+	var buf bytes.Buffer
+	if err := r.template.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("execute template for %s: %w", r.Target, err)
+	}
+
+	return buf.String(), nil
+}
+
+func toString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func appendMissing(values []string, add ...string) []string {
+	for _, a := range add {
+		found := false
+		for _, v := range values {
+			if v == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			values = append(values, a)
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,159 @@
+package transform
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	api "github.com/aserto-dev/go-grpc/aserto/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func createTestUser() *api.User {
+	return &api.User{
+		Id:          "1",
+		DisplayName: "First Last",
+		Email:       "First.Last@Unit.com",
+		Attributes: &api.AttrSet{
+			Properties: &structpb.Struct{Fields: map[string]*structpb.Value{
+				"department": structpb.NewStringValue("eng"),
+				"legacy":     structpb.NewBoolValue(true),
+			}},
+			Roles:       []string{"user", "admins"},
+			Permissions: []string{"read"},
+		},
+		Applications: map[string]*api.AttrSet{
+			"app1": {Roles: []string{"viewer"}},
+			"app2": {Roles: []string{"viewer", "editor"}},
+		},
+	}
+}
+
+func TestApplyListRules(t *testing.T) {
+	assert := require.New(t)
+	p, err := New([]Rule{
+		{Op: OpRename, Target: "attributes.roles", From: "admins", To: "admin"},
+		{Op: OpAdd, Target: "attributes.permissions", Values: []string{"read", "write"}},
+		{Op: OpMap, Target: "applications.*.roles", Mapping: map[string]string{"viewer": "reader"}},
+		{Op: OpRemove, Target: "applications.app2.roles", Values: []string{"editor"}},
+		{Op: OpTemplate, Target: "applications.app1.permissions", Template: "app1:{{.Value}}"},
+	})
+	assert.Nil(err)
+
+	user := createTestUser()
+	user.Applications["app1"].Permissions = []string{"read"}
+	assert.Nil(p.Apply(user, PhaseWrite))
+
+	assert.Equal([]string{"user", "admin"}, user.Attributes.Roles)
+	assert.Equal([]string{"read", "write"}, user.Attributes.Permissions)
+	assert.Equal([]string{"reader"}, user.Applications["app1"].Roles)
+	assert.Equal([]string{"reader"}, user.Applications["app2"].Roles)
+	assert.Equal([]string{"app1:read"}, user.Applications["app1"].Permissions)
+}
+
+func TestApplyPropertyAndScalarRules(t *testing.T) {
+	assert := require.New(t)
+	p, err := New([]Rule{
+		{Op: OpRemove, Target: "attributes.properties.legacy"},
+		{Op: OpRename, Target: "attributes.properties.department", To: "dept"},
+		{Op: OpMap, Target: "attributes.properties.dept", Mapping: map[string]string{"eng": "engineering"}},
+		{Op: OpSet, Target: "applications.app3.properties.tier", Value: "gold"},
+		{Op: OpMap, Target: "email", Mapping: map[string]string{"First.Last@Unit.com": "first.last@unit.com"}},
+		{Op: OpTemplate, Target: "display_name", Template: "{{.DisplayName}} ({{.Properties.dept}})"},
+	})
+	assert.Nil(err)
+
+	user := createTestUser()
+	assert.Nil(p.Apply(user, PhaseRead))
+
+	fields := user.Attributes.Properties.Fields
+	assert.NotContains(fields, "legacy")
+	assert.NotContains(fields, "department")
+	assert.Equal("engineering", fields["dept"].GetStringValue())
+	assert.Equal("gold", user.Applications["app3"].Properties.Fields["tier"].GetStringValue())
+	assert.Equal("first.last@unit.com", user.Email)
+	assert.Equal("First Last (engineering)", user.DisplayName)
+}
+
+func TestApplyPropertyRulesWithoutProperties(t *testing.T) {
+	assert := require.New(t)
+	p, err := New([]Rule{
+		{Op: OpRemove, Target: "attributes.properties.legacy"},
+		{Op: OpRename, Target: "attributes.properties.department", To: "dept"},
+		{Op: OpMap, Target: "attributes.properties.dept", Mapping: map[string]string{"eng": "engineering"}},
+	})
+	assert.Nil(err)
+
+	user := createTestUser()
+	user.Attributes.Properties = nil
+	assert.Nil(p.Apply(user, PhaseRead))
+	assert.Nil(user.Attributes.Properties)
+}
+
+func TestApplyPhase(t *testing.T) {
+	assert := require.New(t)
+	p, err := New([]Rule{
+		{Op: OpSet, Target: "display_name", Value: "Written", Phase: PhaseWrite},
+	})
+	assert.Nil(err)
+
+	user := createTestUser()
+	assert.Nil(p.Apply(user, PhaseRead))
+	assert.Equal("First Last", user.DisplayName)
+
+	assert.Nil(p.Apply(user, PhaseWrite))
+	assert.Equal("Written", user.DisplayName)
+}
+
+func TestNilPipeline(t *testing.T) {
+	assert := require.New(t)
+	var p *Pipeline
+
+	user := createTestUser()
+	assert.Nil(p.Apply(user, PhaseWrite))
+	assert.Equal("First Last", user.DisplayName)
+}
+
+func TestNewInvalidRules(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := New([]Rule{{Op: "upsert", Target: "email"}})
+	assert.EqualError(err, `rule 1: invalid op "upsert"`)
+
+	_, err = New([]Rule{{Op: OpSet, Target: "attributes.groups"}})
+	assert.EqualError(err, `rule 1: invalid target "attributes.groups"`)
+
+	_, err = New([]Rule{{Op: OpSet, Target: "attributes.properties"}})
+	assert.EqualError(err, `rule 1: target "attributes.properties" is missing a property key`)
+
+	_, err = New([]Rule{{Op: OpRename, Target: "email", To: "x"}})
+	assert.EqualError(err, `rule 1: op "rename" cannot target email`)
+
+	_, err = New([]Rule{{Op: OpTemplate, Target: "email", Template: "{{.Value"}})
+	assert.NotNil(err)
+}
+
+func TestLoad(t *testing.T) {
+	assert := require.New(t)
+	path := filepath.Join(t.TempDir(), "transform.yaml")
+	content := `
+rules:
+  - op: add
+    target: attributes.roles
+    values: [member]
+  - op: set
+    target: attributes.properties.imported
+    value: true
+    phase: write
+`
+	assert.Nil(os.WriteFile(path, []byte(content), 0600))
+
+	p, err := Load(path)
+	assert.Nil(err)
+
+	user := createTestUser()
+	assert.Nil(p.Apply(user, PhaseWrite))
+	assert.Equal([]string{"user", "admins", "member"}, user.Attributes.Roles)
+	assert.True(user.Attributes.Properties.Fields["imported"].GetBoolValue())
+}